@@ -0,0 +1,12 @@
+// Command tenpen evaluates, checks and formats tenpen rules.
+package main
+
+import (
+	"os"
+
+	"github.com/nanozuki/tenpen/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
@@ -1,46 +1,269 @@
 package tenpen
 
-import "github.com/nanozuki/tenpen/internal/lg"
+import (
+	"context"
+	"sort"
+	"sync"
 
+	"github.com/nanozuki/tenpen/internal/lg"
+)
+
+// Engine holds the functions and limits for rules. It's safe for concurrent
+// use, functions can be added while its rules are being evaluated.
 type Engine struct {
-	funs []lg.Expr
+	mu sync.RWMutex
+	// funs is copied on write, so a snapshot of it is never modified.
+	funs    []lg.Expr
+	sigs    map[string]lg.Signature // sigs are the signatures of functions, copied on write too
+	limits  Limits
+	backend Backend
+	decimal *DecimalOptions
+	sorted  bool
 }
 
+// Limits caps the resources used by an evaluation, a zero field means no limit.
+type Limits = lg.Limits
+
+// DecimalOptions are the options of decimal mode.
+type DecimalOptions = lg.DecimalOptions
+
+// Backend is the way rules are evaluated, the results are identical.
+type Backend int
+
+const (
+	// BackendTree evaluates rules by walking the compiled expressions.
+	BackendTree Backend = iota
+	// BackendVM evaluates rules by running the compiled bytecode on a stack VM.
+	BackendVM
+)
+
 func NewEngine() *Engine {
 	return &Engine{
 		funs: []lg.Expr{lg.Builtins},
 	}
 }
 
-func (e *Engine) AddFunction(name string, fn lg.GoFn) {
+// userFunctions returns a copy of functions added by user.
+func (e *Engine) userFunctions() lg.Object {
 	if len(e.funs) == 1 {
-		e.funs = append(e.funs, lg.Object{})
+		return lg.NewObject(1)
 	}
-	last := e.funs[1].(lg.Object)
-	last[name] = fn
+	return e.funs[1].(lg.Object).Clone()
 }
 
-func (e *Engine) AddModule(name string, funcs map[string]lg.GoFn) {
-	if len(e.funs) == 1 {
-		e.funs = append(e.funs, lg.Object{})
+func (e *Engine) AddFunction(name string, fn GoFn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	user := e.userFunctions()
+	user.Set(name, fn)
+	e.funs = []lg.Expr{lg.Builtins, user}
+	e.setSignature(name, nil)
+}
+
+// AddTypedFunction adds a function like AddFunction with its signature, the
+// calls of it are checked against sig by Rule.TypeCheck.
+func (e *Engine) AddTypedFunction(name string, fn GoFn, sig Signature) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	user := e.userFunctions()
+	user.Set(name, fn)
+	e.funs = []lg.Expr{lg.Builtins, user}
+	e.setSignature(name, &sig)
+}
+
+// setSignature sets the signature of function name, or removes it if sig is
+// nil. The caller must hold the lock.
+func (e *Engine) setSignature(name string, sig *Signature) {
+	if _, ok := e.sigs[name]; !ok && sig == nil {
+		return
+	}
+	sigs := make(map[string]lg.Signature, len(e.sigs)+1)
+	for k, v := range e.sigs {
+		sigs[k] = v
 	}
-	last := e.funs[1].(lg.Object)
-	if _, ok := last[name]; !ok {
-		last[name] = lg.Object{}
+	delete(sigs, name)
+	if sig != nil {
+		sigs[name] = *sig
+	}
+	e.sigs = sigs
+}
+
+func (e *Engine) AddModule(name string, funcs map[string]GoFn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	user := e.userFunctions()
+	mod := lg.NewObject(len(funcs))
+	if old, ok := user.Get(name); ok {
+		if old, ok := old.(lg.Object); ok {
+			mod = old.Clone()
+		}
 	}
-	mod := last[name].(lg.Object)
 	for k, v := range funcs {
-		mod[k] = v
+		mod.Set(k, v)
+		e.setSignature(name+"."+k, nil)
+	}
+	user.Set(name, mod)
+	e.funs = []lg.Expr{lg.Builtins, user}
+}
+
+// SetLimits sets the limits of every evaluation of the rules by this engine.
+// An evaluation fails with a tperr.LimitExceeded error when it exceeds one.
+func (e *Engine) SetLimits(limits Limits) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.limits = limits
+}
+
+// SetBackend sets the backend of the rules created by this engine after it.
+func (e *Engine) SetBackend(backend Backend) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.backend = backend
+}
+
+// SetDecimal turns on the decimal mode with opts, or turns it off if opts is
+// nil. In decimal mode, the numbers in rules and facts are parsed as exact
+// decimals, the rules created before it are not affected.
+func (e *Engine) SetDecimal(opts *DecimalOptions) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if opts != nil {
+		opts = &DecimalOptions{DivScale: opts.DivScale, Rounding: opts.Rounding}
+	}
+	e.decimal = opts
+}
+
+// SetSortedKeys sets whether the keys of output objects are sorted. They are in
+// the order of rule by default, and the objects from facts keep their order.
+func (e *Engine) SetSortedKeys(sorted bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sorted = sorted
+}
+
+// Functions returns the sorted names of the functions of engine, both builtin
+// and added ones. A function of module is named like "str.upper".
+func (e *Engine) Functions() []string {
+	cfg := e.snapshot()
+	return fnNames(cfg.funs)
+}
+
+// fnNames returns the sorted names of the functions in layers.
+func fnNames(layers []lg.Expr) []string {
+	seen := map[string]struct{}{}
+	var names []string
+	var walk func(expr lg.Expr, prefix string)
+	walk = func(expr lg.Expr, prefix string) {
+		obj, ok := expr.(lg.Object)
+		if !ok {
+			return
+		}
+		for k, v := range obj.All() {
+			switch v.(type) {
+			case lg.Fn:
+				if _, ok := seen[prefix+k]; !ok {
+					seen[prefix+k] = struct{}{}
+					names = append(names, prefix+k)
+				}
+			case lg.Object:
+				walk(v, prefix+k+".")
+			}
+		}
+	}
+	for _, layer := range layers {
+		walk(layer, "")
+	}
+	sort.Strings(names)
+	return names
+}
+
+// config is a snapshot of the engine for parsing and evaluation.
+type config struct {
+	funs    []lg.Expr
+	sigs    map[string]lg.Signature
+	limits  Limits
+	backend Backend
+	decimal *DecimalOptions
+	sorted  bool
+}
+
+// snapshot returns the current config of engine.
+func (e *Engine) snapshot() config {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return config{funs: e.funs, sigs: e.sigs, limits: e.limits, backend: e.backend, decimal: e.decimal, sorted: e.sorted}
+}
+
+// parse parses a rule or facts, the numbers are decimals in decimal mode.
+func (c config) parse(data string) (lg.Expr, error) {
+	if c.decimal != nil {
+		return lg.DecimalFromBytes([]byte(data))
 	}
+	return lg.ExprFromBytes([]byte(data))
 }
 
+// parseEnvs parses the environments of an evaluation.
+func (c config) parseEnvs(envs []string) ([]lg.Expr, error) {
+	vals := make([]lg.Expr, 0, len(envs))
+	for _, env := range envs {
+		val, err := c.parse(env)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
+
+// output serializes the output into json, and checks its size.
+func (c config) output(expr lg.Expr) (string, error) {
+	marshal := lg.ExprToBytes
+	if c.sorted {
+		marshal = lg.ExprToSortedBytes
+	}
+	got, err := marshal(expr)
+	if err != nil {
+		return "", err
+	}
+	if err := c.limits.CheckOutput(len(got)); err != nil {
+		return "", err
+	}
+	return string(got), nil
+}
+
+// compile compiles a parsed rule for the backend.
+func (c config) compile(expr lg.Expr) (lg.Expr, error) {
+	if c.backend == BackendVM {
+		return lg.CompileVM(expr, c.funs)
+	}
+	return lg.Compile(expr, c.funs)
+}
+
+// evaluator returns an evaluator of rule with environments.
+func (c config) evaluator(ctx context.Context, rule lg.Expr, envs []lg.Expr) *lg.Evaluator {
+	e := lg.NewEvaluator(ctx, rule, envs, c.funs, c.limits)
+	if c.decimal != nil {
+		e.WithDecimal(*c.decimal)
+	}
+	return e
+}
+
+// NewRule parses and compiles the rule. The functions of engine are resolved
+// at this time, so a function added later is invisible to the rule, unless
+// it's not found now.
 func (e *Engine) NewRule(rule string) (*Rule, error) {
-	expr, err := lg.ExprFromBytes([]byte(rule))
+	cfg := e.snapshot()
+	expr, err := cfg.parse(rule)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := cfg.compile(expr)
 	if err != nil {
 		return nil, err
 	}
 	return &Rule{
 		expr:   expr,
+		plan:   plan,
 		engine: e,
 	}, nil
 }
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/nanozuki/tenpen"
+)
+
+// runCheck parses and statically checks rules, all the problems of all the
+// files are reported. The types are checked against the facts schema, and
+// printed as a json line for each file with -types. The warnings of type check
+// are written to stderr too, but don't fail the check.
+func runCheck(c *cmd, args []string) error {
+	schemaFile := c.flags.String("schema", "", "the `file` of the facts schema, the missing facts are reported")
+	types := c.flags.Bool("types", false, "print the inferred types of rules")
+	args, err := c.parseFlags(args, 1)
+	if err != nil {
+		return err
+	}
+	var schema string
+	if *schemaFile != "" {
+		data, err := c.readFile(*schemaFile)
+		if err != nil {
+			return err
+		}
+		if _, err := tenpen.ParseType(string(data)); err != nil {
+			return inFile(*schemaFile, err)
+		}
+		schema = string(data)
+	}
+	engine := tenpen.NewEngine()
+	enc := json.NewEncoder(c.stdout)
+	enc.SetEscapeHTML(false)
+	var errs []error
+	for _, name := range args {
+		rule, err := c.loadRule(engine, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, inFile(name, rule.Check()))
+		got, warnings, err := rule.TypeCheck(schema)
+		if warnings != nil {
+			writeErrors(c.stderr, warnings, name, true)
+		}
+		errs = append(errs, inFile(name, err))
+		if *types {
+			out := struct {
+				File  string          `json:"file"`
+				Types json.RawMessage `json:"types"`
+			}{name, json.RawMessage(got)}
+			if err := enc.Encode(out); err != nil {
+				return ioError{err}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,236 @@
+// Package cli implements the tenpen command.
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// The exit codes of tenpen.
+const (
+	ExitOK    = 0 // ExitOK means the command succeeded
+	ExitRule  = 1 // ExitRule means a rule or facts is invalid, or fails to evaluate
+	ExitUsage = 2 // ExitUsage means the command line is invalid
+	ExitIO    = 3 // ExitIO means a file can't be read or written
+)
+
+const usage = `usage: tenpen <command> [flags] [args]
+
+commands:
+  eval   evaluate a rule with stacked fact files
+  check  parse and statically check rules and their types
+  fmt    reformat rules canonically
+  repl   evaluate expressions interactively
+  test   run the test suites of rules
+
+Run "tenpen <command> -h" for the flags of a command. Errors and warnings are
+written to stderr as json lines.
+`
+
+// command is a subcommand of tenpen.
+type command struct {
+	run   func(c *cmd, args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"eval":  {runEval, "tenpen eval [flags] rule.json [facts.json...]"},
+	"check": {runCheck, "tenpen check [flags] rule.json..."},
+	"fmt":   {runFmt, "tenpen fmt [flags] [rule.json...]"},
+	"repl":  {runRepl, "tenpen repl [flags] [facts.json...]"},
+	"test":  {runTest, "tenpen test [flags] [dir|file.test.json...]"},
+}
+
+// cmd is the environment of a command.
+type cmd struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	flags  *flag.FlagSet
+}
+
+// Run runs tenpen with args, which don't include the program name, and returns
+// the exit code.
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+	if args[0] == "-h" || args[0] == "help" || args[0] == "--help" {
+		fmt.Fprint(stdout, usage)
+		return ExitOK
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		writeError(stderr, usageError("unknown command %q", args[0]), "", false)
+		return ExitUsage
+	}
+	c := &cmd{stdin: stdin, stdout: stdout, stderr: stderr}
+	c.flags = flag.NewFlagSet(args[0], flag.ContinueOnError)
+	c.flags.SetOutput(stderr)
+	c.flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s\n", command.usage)
+		c.flags.PrintDefaults()
+	}
+	err := command.run(c, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	return c.exit(err)
+}
+
+// exit writes err and returns the exit code of it.
+func (c *cmd) exit(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	writeErrors(c.stderr, err, "", false)
+	var ue *usageErr
+	switch {
+	case errors.As(err, &ue):
+		return ExitUsage
+	case isIOError(err):
+		return ExitIO
+	default:
+		return ExitRule
+	}
+}
+
+// parseFlags parses the flags of command, and checks the number of args.
+func (c *cmd) parseFlags(args []string, minArgs int) ([]string, error) {
+	if err := c.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, usageError("%s", err)
+	}
+	if c.flags.NArg() < minArgs {
+		c.flags.Usage()
+		return nil, usageError("too few arguments")
+	}
+	return c.flags.Args(), nil
+}
+
+// readFile reads a file, or stdin if name is "-".
+func (c *cmd) readFile(name string) ([]byte, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(c.stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, &fileError{file: name, err: ioError{err}}
+	}
+	return data, nil
+}
+
+// errorOutput is an error written to stderr as a json line.
+type errorOutput struct {
+	Error    string `json:"error"`
+	Warning  bool   `json:"warning,omitempty"` // Warning is true if it doesn't change the exit code
+	Kind     string `json:"kind,omitempty"`    // Kind is the message of tperr.Error, like "invalid type"
+	Location string `json:"location,omitempty"`
+	Fn       string `json:"fn,omitempty"`
+	Expr     string `json:"expr,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	File     string `json:"file,omitempty"`
+}
+
+func writeError(w io.Writer, err error, file string, warning bool) {
+	out := errorOutput{Error: err.Error(), Warning: warning, File: file}
+	var te *tperr.Error
+	var ue *usageErr
+	switch {
+	case errors.As(err, &te):
+		out.Kind = string(te.Message)
+		out.Location = te.Location
+		out.Fn = te.Fn
+		out.Expr = te.Expr
+		out.Detail = te.Detail
+	case errors.As(err, &ue):
+		out.Kind = "usage"
+	case isIOError(err):
+		out.Kind = "io"
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(out)
+}
+
+// writeErrors writes the errors joined by errors.Join one per line, file is the
+// file they occur in, unless they are in a fileError. They are written as
+// warnings if warning is true.
+func writeErrors(w io.Writer, err error, file string, warning bool) {
+	if fe, ok := err.(*fileError); ok {
+		writeErrors(w, fe.err, fe.file, warning)
+		return
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			writeErrors(w, e, file, warning)
+		}
+		return
+	}
+	writeError(w, err, file, warning)
+}
+
+// usageErr is an error of the command line.
+type usageErr struct {
+	msg string
+}
+
+func usageError(format string, args ...any) error {
+	return &usageErr{msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *usageErr) Error() string {
+	return e.msg
+}
+
+// ioError is an error of reading or writing files.
+type ioError struct {
+	err error
+}
+
+func (e ioError) Error() string {
+	return e.err.Error()
+}
+
+func (e ioError) Unwrap() error {
+	return e.err
+}
+
+func isIOError(err error) bool {
+	var ie ioError
+	return errors.As(err, &ie)
+}
+
+// fileError is an error in file.
+type fileError struct {
+	file string
+	err  error
+}
+
+func (e *fileError) Error() string {
+	return e.file + ": " + e.err.Error()
+}
+
+func (e *fileError) Unwrap() error {
+	return e.err
+}
+
+// inFile attaches file to err.
+func inFile(file string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fileError{file: file, err: err}
+}
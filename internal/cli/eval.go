@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// runEval evaluates a rule with fact files, the later files take precedence
+// over the earlier ones.
+func runEval(c *cmd, args []string) error {
+	var opts engineOptions
+	opts.register(c)
+	pretty := c.flags.Bool("pretty", false, "indent the output")
+	explain := c.flags.Bool("explain", false, "print how the result is derived, instead of the result")
+	timeout := c.flags.Duration("timeout", 0, "cancel the evaluation after the duration, 0 means no timeout")
+	args, err := c.parseFlags(args, 1)
+	if err != nil {
+		return err
+	}
+	engine, err := opts.engine()
+	if err != nil {
+		return err
+	}
+	rule, err := c.loadRule(engine, args[0])
+	if err != nil {
+		return err
+	}
+	envs := make([]string, 0, len(args)-1)
+	for _, name := range args[1:] {
+		data, err := c.readFile(name)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(data) {
+			return inFile(name, tperr.InvalidJSONError())
+		}
+		envs = append(envs, string(data))
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	if *explain {
+		return c.explain(ctx, rule, args, envs)
+	}
+	got, err := rule.EvalContext(ctx, envs...)
+	if err != nil {
+		return inFile(args[0], err)
+	}
+	if *pretty {
+		var b bytes.Buffer
+		if err := json.Indent(&b, []byte(got), "", "  "); err != nil {
+			return err
+		}
+		got = b.String()
+	}
+	if _, err := fmt.Fprintln(c.stdout, got); err != nil {
+		return ioError{err}
+	}
+	return nil
+}
+
+// explain prints the derivation of rule, args are the rule and fact files,
+// and envs are the facts read from them. The derivation is printed even if the
+// evaluation fails, to see where. The evaluation is stopped once ctx is done.
+func (c *cmd) explain(ctx context.Context, rule *tenpen.Rule, args []string, envs []string) error {
+	x := &explainer{w: c.stdout, sources: map[string]string{"rule": args[0]}}
+	for i, name := range args[1:] {
+		x.sources[fmt.Sprintf("env %d", i)] = name
+	}
+	trace, evalErr := rule.EvalTraceContext(ctx, envs...)
+	if trace != "" {
+		if err := x.explain(trace); err != nil {
+			return err
+		}
+	}
+	return inFile(args[0], evalErr)
+}
+
+// engineOptions are the flags to set up an engine.
+type engineOptions struct {
+	backend *string
+	decimal *bool
+	sorted  *bool
+}
+
+func (o *engineOptions) register(c *cmd) {
+	o.backend = c.flags.String("backend", "tree", "the backend to evaluate rules, tree or vm")
+	o.decimal = c.flags.Bool("decimal", false, "parse numbers as exact decimals")
+	o.sorted = c.flags.Bool("sorted", false, "sort the keys of output objects")
+}
+
+func (o *engineOptions) engine() (*tenpen.Engine, error) {
+	engine := tenpen.NewEngine()
+	switch *o.backend {
+	case "tree":
+		engine.SetBackend(tenpen.BackendTree)
+	case "vm":
+		engine.SetBackend(tenpen.BackendVM)
+	default:
+		return nil, usageError("unknown backend %q", *o.backend)
+	}
+	if *o.decimal {
+		engine.SetDecimal(&tenpen.DecimalOptions{})
+	}
+	engine.SetSortedKeys(*o.sorted)
+	return engine, nil
+}
+
+// loadRule reads and parses the rule in file.
+func (c *cmd) loadRule(engine *tenpen.Engine, name string) (*tenpen.Rule, error) {
+	data, err := c.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	rule, err := engine.NewRule(string(data))
+	if err != nil {
+		return nil, inFile(name, err)
+	}
+	return rule, nil
+}
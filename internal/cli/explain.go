@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxValueWidth is the width that a value in an explanation is cut beyond.
+const maxValueWidth = 60
+
+// traceStep is a step of the trace by Rule.EvalTrace.
+type traceStep struct {
+	Path   string            `json:"path"`
+	Expr   json.RawMessage   `json:"expr"`
+	Source string            `json:"source"`
+	Fn     string            `json:"fn"`
+	Args   []json.RawMessage `json:"args"`
+	Result json.RawMessage   `json:"result"`
+	Error  string            `json:"error"`
+	Steps  []traceStep       `json:"steps"`
+}
+
+// explainer renders a trace as an indented derivation, a line for a step:
+//
+//	total = $-(20, 2) = 18
+//	  #subtotal = 20 (rule)
+//	  #discount = 2 (rule)
+//
+// The literal values, and the objects and arrays of them are omitted, as they
+// are in the arguments.
+type explainer struct {
+	w       io.Writer
+	sources map[string]string // sources are the names of layers, like the fact files
+}
+
+func (x *explainer) explain(trace string) error {
+	var root traceStep
+	if err := json.Unmarshal([]byte(trace), &root); err != nil {
+		return err
+	}
+	x.step(root, 0, true)
+	return nil
+}
+
+// step writes the step at depth, it's labeled by its path if it's the root or
+// a child of an object or array.
+func (x *explainer) step(s traceStep, depth int, labeled bool) {
+	container := s.Fn == "" && s.Source == "" && isContainer(s.Expr)
+	if !labeled && isLiteral(s) {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(strings.Repeat("  ", depth))
+	label := s.Path
+	if label == "" {
+		label = "(root)"
+	}
+	switch {
+	case container:
+		b.WriteString(label)
+	case isRef(s.Expr):
+		if labeled {
+			b.WriteString(label + " = ")
+		}
+		var ref string
+		_ = json.Unmarshal(s.Expr, &ref)
+		b.WriteString(ref)
+		x.result(&b, s)
+		if s.Source != "" {
+			fmt.Fprintf(&b, " (%s)", x.source(s.Source))
+		}
+	case s.Fn != "":
+		if labeled {
+			b.WriteString(label + " = ")
+		}
+		b.WriteString(s.Fn)
+		if s.Args != nil {
+			args := make([]string, len(s.Args))
+			for i, arg := range s.Args {
+				args[i] = valueText(arg)
+			}
+			fmt.Fprintf(&b, "(%s)", strings.Join(args, ", "))
+		}
+		x.result(&b, s)
+	default:
+		b.WriteString(label)
+		x.result(&b, s)
+	}
+	fmt.Fprintln(x.w, b.String())
+	for _, child := range s.Steps {
+		x.step(child, depth+1, container)
+	}
+}
+
+func (x *explainer) result(b *strings.Builder, s traceStep) {
+	if s.Error != "" {
+		b.WriteString(" ! " + s.Error)
+		return
+	}
+	b.WriteString(" = " + valueText(s.Result))
+}
+
+func (x *explainer) source(name string) string {
+	if file, ok := x.sources[name]; ok {
+		return file
+	}
+	return name
+}
+
+// isLiteral reports whether the step and its steps call no function and
+// reference no value.
+func isLiteral(s traceStep) bool {
+	if s.Fn != "" || s.Source != "" || s.Error != "" {
+		return false
+	}
+	for _, child := range s.Steps {
+		if !isLiteral(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// isRef reports whether expr is a value reference.
+func isRef(expr json.RawMessage) bool {
+	var s string
+	return json.Unmarshal(expr, &s) == nil && strings.HasPrefix(s, "#")
+}
+
+// isContainer reports whether expr is an object or array, not a function call.
+func isContainer(expr json.RawMessage) bool {
+	expr = bytes.TrimSpace(expr)
+	if len(expr) == 0 {
+		return false
+	}
+	if expr[0] == '{' {
+		return true
+	}
+	if expr[0] != '[' {
+		return false
+	}
+	var arr []any
+	if json.Unmarshal(expr, &arr) != nil || len(arr) == 0 {
+		return true
+	}
+	head, ok := arr[0].(string)
+	return !ok || !strings.HasPrefix(head, "$")
+}
+
+// valueText returns the json text of a value, cut to maxValueWidth.
+func valueText(v json.RawMessage) string {
+	var b bytes.Buffer
+	if err := json.Compact(&b, v); err != nil {
+		return string(v)
+	}
+	text := b.String()
+	if len(text) > maxValueWidth {
+		return text[:maxValueWidth-3] + "..."
+	}
+	return text
+}
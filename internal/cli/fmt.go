@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// lineWidth is the width that an array is broken into lines beyond.
+const lineWidth = 80
+
+// runFmt reformats rules canonically. Objects have a key per line, and arrays,
+// like function calls, are kept in a line if they fit. Keys keep their order,
+// and numbers and strings keep their values.
+func runFmt(c *cmd, args []string) error {
+	write := c.flags.Bool("w", false, "write the result to the files instead of stdout")
+	list := c.flags.Bool("l", false, "list the files whose formatting differs, and exit with 1 if any")
+	args, err := c.parseFlags(args, 0)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		if *write {
+			return usageError("can't write the result with stdin")
+		}
+		args = []string{"-"}
+	}
+	var unformatted bool
+	for _, name := range args {
+		src, err := c.readFile(name)
+		if err != nil {
+			return err
+		}
+		got, err := format(src)
+		if err != nil {
+			return inFile(name, err)
+		}
+		switch {
+		case *list:
+			if !bytes.Equal(src, got) {
+				unformatted = true
+				fmt.Fprintln(c.stdout, name)
+			}
+		case *write && name != "-":
+			if bytes.Equal(src, got) {
+				continue
+			}
+			if err := writeFile(name, got); err != nil {
+				return inFile(name, ioError{err})
+			}
+		default:
+			if _, err := c.stdout.Write(got); err != nil {
+				return ioError{err}
+			}
+		}
+	}
+	if unformatted {
+		return errUnformatted
+	}
+	return nil
+}
+
+// errUnformatted is returned by "fmt -l" if some files are not formatted.
+var errUnformatted = errors.New("some files are not formatted")
+
+func writeFile(name string, data []byte) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, info.Mode().Perm())
+}
+
+// format returns the canonical form of json src.
+func format(src []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	n, err := decodeNode(dec)
+	if err != nil {
+		return nil, tperr.InvalidJSONError().WithDetail("%s", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, tperr.InvalidJSONError().WithDetail("unexpected data after the value")
+	}
+	var b bytes.Buffer
+	n.write(&b, "", 0)
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// node is a json value with the text of scalars and the order of keys.
+type node struct {
+	text   string  // text is the json text of a scalar
+	array  []*node // array is the elements of an array, not nil for an array
+	keys   []string
+	fields map[string]*node // fields is not nil for an object
+}
+
+func decodeNode(dec *json.Decoder) (*node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			n := &node{array: []*node{}}
+			for dec.More() {
+				elem, err := decodeNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				n.array = append(n.array, elem)
+			}
+			_, err := dec.Token()
+			return n, err
+		}
+		n := &node{fields: map[string]*node{}}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			k := key.(string)
+			val, err := decodeNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			// a duplicate key keeps its first place and takes the last value,
+			// as rules do
+			if _, ok := n.fields[k]; !ok {
+				n.keys = append(n.keys, k)
+			}
+			n.fields[k] = val
+		}
+		_, err := dec.Token()
+		return n, err
+	case json.Number:
+		return &node{text: tok.String()}, nil
+	case string:
+		return &node{text: quote(tok)}, nil
+	case bool:
+		return &node{text: fmt.Sprint(tok)}, nil
+	default:
+		return &node{text: "null"}, nil
+	}
+}
+
+// quote returns the json text of s, without escaping html characters.
+func quote(s string) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// write writes the node at the column col of a line, whose indent is indent.
+func (n *node) write(b *bytes.Buffer, indent string, col int) {
+	switch {
+	case n.fields != nil:
+		if len(n.keys) == 0 {
+			b.WriteString("{}")
+			return
+		}
+		inner := indent + "  "
+		b.WriteString("{\n")
+		for i, k := range n.keys {
+			key := quote(k) + ": "
+			b.WriteString(inner)
+			b.WriteString(key)
+			n.fields[k].write(b, inner, len(inner)+len(key))
+			if i < len(n.keys)-1 {
+				b.WriteByte(',')
+			}
+			b.WriteByte('\n')
+		}
+		b.WriteString(indent)
+		b.WriteByte('}')
+	case n.array != nil:
+		if line, ok := n.line(); ok && col+len(line) <= lineWidth {
+			b.WriteString(line)
+			return
+		}
+		inner := indent + "  "
+		b.WriteString("[\n")
+		for i, elem := range n.array {
+			b.WriteString(inner)
+			elem.write(b, inner, len(inner))
+			if i < len(n.array)-1 {
+				b.WriteByte(',')
+			}
+			b.WriteByte('\n')
+		}
+		b.WriteString(indent)
+		b.WriteByte(']')
+	default:
+		b.WriteString(n.text)
+	}
+}
+
+// line returns the node in a line, ok is false if it has a non-empty object.
+func (n *node) line() (line string, ok bool) {
+	switch {
+	case n.fields != nil:
+		return "{}", len(n.keys) == 0
+	case n.array != nil:
+		items := make([]string, len(n.array))
+		for i, elem := range n.array {
+			if items[i], ok = elem.line(); !ok {
+				return "", false
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]", true
+	default:
+		return n.text, true
+	}
+}
@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nanozuki/tenpen"
+)
+
+const replHelp = `Type a json expression to evaluate it, like ["$+", "#a", 1]. An object
+defines its keys for the later expressions, like {"inc": ["$def", ["x"], ["$+", "#x", 1]]}.
+An expression may span lines until it's complete.
+
+commands:
+  :load file  load facts as a new layer
+  :env        print the values in scope
+  :funcs      list the functions of the engine and the defined ones
+  :help       print this help
+  :quit       exit
+`
+
+// runRepl reads expressions from stdin, and prints the results with their
+// types. Errors are printed as text, as it's for humans.
+func runRepl(c *cmd, args []string) error {
+	decimal := c.flags.Bool("decimal", false, "parse numbers as exact decimals")
+	args, err := c.parseFlags(args, 0)
+	if err != nil {
+		return err
+	}
+	engine := tenpen.NewEngine()
+	if *decimal {
+		engine.SetDecimal(&tenpen.DecimalOptions{})
+	}
+	r := &repl{cmd: c, engine: engine}
+	if r.session, err = engine.NewSession(); err != nil {
+		return err
+	}
+	for _, name := range args {
+		if err := r.load(name); err != nil {
+			return err
+		}
+	}
+	return r.loop()
+}
+
+type repl struct {
+	*cmd
+	engine  *tenpen.Engine
+	session *tenpen.Session
+}
+
+func (r *repl) loop() error {
+	scanner := bufio.NewScanner(r.stdin)
+	scanner.Buffer(nil, 1<<20)
+	var input strings.Builder
+	r.prompt("> ")
+	for scanner.Scan() {
+		line := scanner.Text()
+		if input.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if quit := r.command(strings.Fields(line)); quit {
+				return nil
+			}
+			r.prompt("> ")
+			continue
+		}
+		input.WriteString(line)
+		input.WriteByte('\n')
+		expr := input.String()
+		if strings.TrimSpace(expr) == "" {
+			input.Reset()
+			r.prompt("> ")
+			continue
+		}
+		if incomplete(expr) {
+			r.prompt("... ")
+			continue
+		}
+		input.Reset()
+		r.eval(expr)
+		r.prompt("> ")
+	}
+	if err := scanner.Err(); err != nil {
+		return ioError{err}
+	}
+	fmt.Fprintln(r.stdout)
+	return nil
+}
+
+func (r *repl) prompt(p string) {
+	fmt.Fprint(r.stdout, p)
+}
+
+// incomplete reports whether expr is a prefix of a json value.
+func incomplete(expr string) bool {
+	var raw json.RawMessage
+	err := json.Unmarshal([]byte(expr), &raw)
+	var se *json.SyntaxError
+	return errors.As(err, &se) && se.Offset == int64(len(expr))
+}
+
+func (r *repl) eval(expr string) {
+	got, err := r.session.Eval(context.Background(), expr)
+	if err != nil {
+		r.printError(err)
+		return
+	}
+	fmt.Fprintln(r.stdout, got.String())
+}
+
+// command runs a command of the repl, and reports whether to quit.
+func (r *repl) command(fields []string) (quit bool) {
+	switch name, args := fields[0], fields[1:]; name {
+	case ":load":
+		if len(args) != 1 {
+			r.printError(usageError("usage: :load file"))
+			return false
+		}
+		if err := r.load(args[0]); err != nil {
+			r.printError(err)
+		}
+	case ":env":
+		fmt.Fprintln(r.stdout, r.session.Env().String())
+	case ":funcs":
+		r.printNames("functions", r.engine.Functions())
+		r.printNames("defined", r.session.Functions())
+	case ":help":
+		fmt.Fprint(r.stdout, replHelp)
+	case ":quit", ":q":
+		return true
+	default:
+		r.printError(usageError("unknown command %s, try :help", name))
+	}
+	return false
+}
+
+func (r *repl) load(name string) error {
+	data, err := r.readFile(name)
+	if err != nil {
+		return err
+	}
+	return inFile(name, r.session.Load(string(data)))
+}
+
+func (r *repl) printError(err error) {
+	fmt.Fprintf(r.stderr, "error: %s\n", err)
+}
+
+// printNames prints names after title, wrapped to the line width.
+func (r *repl) printNames(title string, names []string) {
+	if len(names) == 0 {
+		fmt.Fprintf(r.stdout, "%s: none\n", title)
+		return
+	}
+	fmt.Fprintf(r.stdout, "%s:\n", title)
+	line := " "
+	for _, name := range names {
+		if len(line)+1+len(name) > lineWidth {
+			fmt.Fprintln(r.stdout, line)
+			line = " "
+		}
+		line += " " + name
+	}
+	fmt.Fprintln(r.stdout, line)
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/nanozuki/tenpen/tenpentest"
+)
+
+// runTest runs the suite files, a directory is searched for them recursively.
+// The failures are printed with their differences.
+func runTest(c *cmd, args []string) error {
+	verbose := c.flags.Bool("v", false, "print the passed cases too")
+	args, err := c.parseFlags(args, 0)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return ioError{err}
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		found, err := tenpentest.Files(arg)
+		if err != nil {
+			return ioError{err}
+		}
+		files = append(files, found...)
+	}
+	if len(files) == 0 {
+		return usageError("no %s files in %v", tenpentest.Ext, args)
+	}
+	failed := 0
+	for _, file := range files {
+		s, err := tenpentest.Load(file)
+		if err != nil {
+			if errors.As(err, new(*fs.PathError)) {
+				return inFile(file, ioError{err})
+			}
+			return inFile(file, err)
+		}
+		passed := true
+		for _, tc := range s.Cases {
+			failures := s.RunCase(tc)
+			switch {
+			case len(failures) > 0:
+				passed = false
+				fmt.Fprintf(c.stdout, "--- FAIL: %s: %s\n", file, tc.Name)
+				for _, f := range failures {
+					fmt.Fprintf(c.stdout, "    %s\n", f)
+				}
+			case *verbose:
+				fmt.Fprintf(c.stdout, "--- PASS: %s: %s\n", file, tc.Name)
+			}
+		}
+		if passed {
+			fmt.Fprintf(c.stdout, "ok   %s (%d cases)\n", file, len(s.Cases))
+		} else {
+			failed++
+			fmt.Fprintf(c.stdout, "FAIL %s\n", file)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d suites failed", failed, len(files))
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package lg
+
+import "github.com/nanozuki/tenpen/tperr"
+
+// The callback of the higher-order functions is called with the element and
+// the index, and the accumulator goes first for reduce. A function defined by
+// "$def" can ignore the trailing arguments, but a Go function only receives
+// the leading ones, that is the element, or the accumulator and the element.
+
+func arrayAndFn(args []Expr, n int) (Array, Fn, error) {
+	if len(args) != n {
+		return nil, nil, arityError(n, len(args))
+	}
+	arr, err := as[Array](args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	fn, err := as[Fn](args[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	return arr, fn, nil
+}
+
+func callFn(e Evaller, fn Fn, leading int, args ...Expr) (Expr, error) {
+	if err := e.Context().Err(); err != nil {
+		return nil, tperr.CanceledError(err)
+	}
+	if _, ok := fn.(TenpenFn); !ok {
+		args = args[:leading]
+	}
+	return fn.Apply(e, args)
+}
+
+func callPredicate(e Evaller, fn Fn, elem Expr, i int) (bool, error) {
+	v, err := callFn(e, fn, 1, elem, Int(i))
+	if err != nil {
+		return false, err
+	}
+	b, err := as[Bool](v)
+	if err != nil {
+		return false, err
+	}
+	return bool(b), nil
+}
+
+// mapArray: ["$map", array, fn]
+func mapArray(e Evaller, args []Expr) (Expr, error) {
+	arr, fn, err := arrayAndFn(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	result := make(Array, 0, len(arr))
+	for i, elem := range arr {
+		v, err := callFn(e, fn, 1, elem, Int(i))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// filter: ["$filter", array, fn]
+func filter(e Evaller, args []Expr) (Expr, error) {
+	arr, fn, err := arrayAndFn(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	result := Array{}
+	for i, elem := range arr {
+		ok, err := callPredicate(e, fn, elem, i)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, elem)
+		}
+	}
+	return result, nil
+}
+
+// reduce: ["$reduce", array, fn, init], the first element is the initial
+// value if init is omitted.
+func reduce(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, arityError("2 or 3", len(args))
+	}
+	arr, fn, err := arrayAndFn(args[:2], 2)
+	if err != nil {
+		return nil, err
+	}
+	var acc Expr = Null{}
+	start := 0
+	if len(args) == 3 {
+		acc = args[2]
+	} else if len(arr) > 0 {
+		acc, start = arr[0], 1
+	}
+	for i := start; i < len(arr); i++ {
+		acc, err = callFn(e, fn, 2, acc, arr[i], Int(i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return acc, nil
+}
+
+// some: ["$some", array, fn]
+func some(e Evaller, args []Expr) (Expr, error) {
+	arr, fn, err := arrayAndFn(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	for i, elem := range arr {
+		ok, err := callPredicate(e, fn, elem, i)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return Bool(true), nil
+		}
+	}
+	return Bool(false), nil
+}
+
+// every: ["$every", array, fn]
+func every(e Evaller, args []Expr) (Expr, error) {
+	arr, fn, err := arrayAndFn(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	for i, elem := range arr {
+		ok, err := callPredicate(e, fn, elem, i)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return Bool(false), nil
+		}
+	}
+	return Bool(true), nil
+}
+
+// find: ["$find", array, fn], returns null if not found.
+func find(e Evaller, args []Expr) (Expr, error) {
+	arr, fn, err := arrayAndFn(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	for i, elem := range arr {
+		ok, err := callPredicate(e, fn, elem, i)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return elem, nil
+		}
+	}
+	return Null{}, nil
+}
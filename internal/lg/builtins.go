@@ -1,71 +1,220 @@
 package lg
 
 import (
-	"fmt"
+	"math"
 
 	"github.com/nanozuki/tenpen/tperr"
 )
 
-var Builtins = Object{
-	"+": GoFn(add),
-	"-": GoFn(sub),
-	"*": GoFn(mul),
-	"/": GoFn(div),
-}
+var Builtins = ObjectOf(map[string]Expr{
+	"+":     GoFn(add),
+	"-":     GoFn(sub),
+	"*":     GoFn(mul),
+	"/":     GoFn(div),
+	"round": GoFn(round),
+
+	"and": Form(and),
+	"or":  Form(or),
+	"not": GoFn(not),
+	"==":  GoFn(eq),
+	"!=":  GoFn(ne),
+	">":   GoFn(gt),
+	"<":   GoFn(lt),
+	">=":  GoFn(ge),
+	"<=":  GoFn(le),
+
+	"if":    Form(ifForm),
+	"cond":  Form(cond),
+	"let":   Form(let),
+	"do":    Form(do),
+	"apply": Form(apply),
+
+	"map":    GoFn(mapArray),
+	"filter": GoFn(filter),
+	"reduce": GoFn(reduce),
+	"some":   GoFn(some),
+	"every":  GoFn(every),
+	"find":   GoFn(find),
+
+	"len":         GoFn(length),
+	"split":       GoFn(split),
+	"join":        GoFn(join),
+	"upper":       GoFn(upper),
+	"lower":       GoFn(lower),
+	"trim":        GoFn(trim),
+	"substr":      GoFn(substr),
+	"starts-with": GoFn(startsWith),
+	"ends-with":   GoFn(endsWith),
+	"contains":    GoFn(contains),
+	"replace":     GoFn(replace),
+	"pad":         GoFn(pad),
+
+	"keys":         GoFn(keys),
+	"values":       GoFn(values),
+	"get":          GoFn(get),
+	"merge":        GoFn(merge),
+	"deep-merge":   GoFn(deepMerge),
+	"pick":         GoFn(pick),
+	"omit":         GoFn(omit),
+	"entries":      GoFn(entries),
+	"from-entries": GoFn(fromEntries),
+	"set":          GoFn(set),
+})
 
 func add(e Evaller, args []Expr) (Expr, error) {
-	sum := 0.0
-	for _, arg := range args {
-		n, ok := arg.(Number)
-		fmt.Println(arg, n, ok)
-		if !ok {
-			return nil, tperr.InvalidTypeError()
-		}
-		sum += float64(n)
+	if len(args) > 0 && args[0].Type() == ExprString {
+		return concat(args)
 	}
-	return Number(sum), nil
+	return fold(args, 0, promoteInt(addInt, Decimal.Add), func(a, b float64) float64 { return a + b }, func(a, b Decimal) (Decimal, error) {
+		return a.Add(b), nil
+	})
 }
 
 func sub(e Evaller, args []Expr) (Expr, error) {
-	sum := 0.0
-	for i, arg := range args {
-		n, ok := arg.(Number)
-		if !ok {
-			return nil, tperr.InvalidTypeError()
+	return fold(args, 0, promoteInt(subInt, Decimal.Sub), func(a, b float64) float64 { return a - b }, func(a, b Decimal) (Decimal, error) {
+		return a.Sub(b), nil
+	})
+}
+
+func mul(e Evaller, args []Expr) (Expr, error) {
+	return fold(args, 1, promoteInt(mulInt, Decimal.Mul), func(a, b float64) float64 { return a * b }, func(a, b Decimal) (Decimal, error) {
+		return a.Mul(b), nil
+	})
+}
+
+func div(e Evaller, args []Expr) (Expr, error) {
+	if len(args) == 0 {
+		return nil, arityError("at least 1", 0)
+	}
+	for _, arg := range args[1:] {
+		if isZero(arg) {
+			return nil, tperr.InvalidArgError().WithDetail("division by zero")
 		}
-		if i == 0 {
-			sum = float64(n)
-		} else {
-			sum -= float64(n)
+	}
+	opts := decimalOptions(e)
+	quo := func(a, b int64) (Expr, bool) {
+		if a == math.MinInt64 && b == -1 {
+			// the only quotient that overflows, it's exact as a decimal
+			return NewDecimal(a, 0).Mul(NewDecimal(b, 0)), true
 		}
+		r, ok := quoInt(a, b)
+		return Int(r), ok
 	}
-	return Number(sum), nil
+	return fold(args, 1, quo, func(a, b float64) float64 { return a / b }, func(a, b Decimal) (Decimal, error) {
+		return a.Quo(b, opts.divScale(), opts.Rounding)
+	})
 }
 
-func mul(e Evaller, args []Expr) (Expr, error) {
-	sum := 1.0
-	for _, arg := range args {
-		n, ok := arg.(Number)
-		if !ok {
-			return nil, tperr.InvalidTypeError()
+// promoteInt returns an op of two integers by iop, the integers are applied by
+// dop as decimals if iop overflows, so the result is still exact.
+func promoteInt(iop func(a, b int64) (int64, bool), dop func(a, b Decimal) Decimal) func(a, b int64) (Expr, bool) {
+	return func(a, b int64) (Expr, bool) {
+		if r, ok := iop(a, b); ok {
+			return Int(r), true
 		}
-		sum *= float64(n)
+		return dop(NewDecimal(a, 0), NewDecimal(b, 0)), true
 	}
-	return Number(sum), nil
 }
 
-func div(e Evaller, args []Expr) (Expr, error) {
-	sum := 1.0
-	for i, arg := range args {
-		n, ok := arg.(Number)
-		if !ok {
-			return nil, tperr.InvalidTypeError()
+// isZero reports whether n is a number of zero.
+func isZero(n Expr) bool {
+	switch n := n.(type) {
+	case Int:
+		return n == 0
+	case Number:
+		return n == 0
+	case Decimal:
+		return n.Sign() == 0
+	default:
+		return false
+	}
+}
+
+// fold applies op to the numbers from left to right, it's empty if there is no
+// number. Two integers are applied by iop, and by fop as floats if iop isn't
+// ok, like on an inexact quotient. Two floats, or a float and an integer, are applied by
+// fop, the others are converted to decimals and applied by dop.
+func fold(
+	args []Expr,
+	empty Int,
+	iop func(a, b int64) (Expr, bool),
+	fop func(a, b float64) float64,
+	dop func(a, b Decimal) (Decimal, error),
+) (Expr, error) {
+	if len(args) == 0 {
+		return empty, nil
+	}
+	acc := args[0]
+	if _, err := toFloat(acc); err != nil {
+		return nil, err
+	}
+	for _, arg := range args[1:] {
+		a, aok := acc.(Int)
+		b, bok := arg.(Int)
+		if aok && bok {
+			if r, ok := iop(int64(a), int64(b)); ok {
+				acc = r
+				continue
+			}
+		}
+		_, adec := acc.(Decimal)
+		_, bdec := arg.(Decimal)
+		if !adec && !bdec {
+			fa, err := toFloat(acc)
+			if err != nil {
+				return nil, err
+			}
+			fb, err := toFloat(arg)
+			if err != nil {
+				return nil, err
+			}
+			acc = Number(fop(fa, fb))
+			continue
+		}
+		da, err := toDecimal(acc)
+		if err != nil {
+			return nil, err
+		}
+		db, err := toDecimal(arg)
+		if err != nil {
+			return nil, err
+		}
+		if acc, err = dop(da, db); err != nil {
+			return nil, err
 		}
-		if i == 0 {
-			sum = float64(n)
-		} else {
-			sum /= float64(n)
+	}
+	return acc, nil
+}
+
+// round: ["$round", number, scale?], a decimal is rounded by the rounding mode
+// of engine, and keeps the scale. A negative scale rounds to tens, hundreds and
+// so on.
+func round(e Evaller, args []Expr) (Expr, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, arityError("1 or 2", len(args))
+	}
+	scale := 0
+	if len(args) == 2 {
+		var err error
+		if scale, err = intArg(args[1]); err != nil {
+			return nil, err
+		}
+		if scale > maxExponent || scale < -maxExponent {
+			return nil, tperr.InvalidArgError().WithDetail("scale %d is out of range ±%d", scale, maxExponent)
+		}
+	}
+	d, err := toDecimal(args[0])
+	if err != nil {
+		return nil, err
+	}
+	d = d.Round(scale, decimalOptions(e).Rounding)
+	switch args[0].(type) {
+	case Number:
+		return Number(d.Float64()), nil
+	case Int:
+		if n, ok := d.Int64(); ok {
+			return Int(n), nil
 		}
 	}
-	return Number(sum), nil
+	return d, nil
 }
@@ -0,0 +1,254 @@
+package lg
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// Block is a compiled object or array. Its children are compiled, and sorted in
+// the order of evaluation, so the dependencies are not analyzed again.
+type Block struct {
+	Expr   Expr   // Expr is the original object or array
+	Steps  []Step // Steps are the keys or indices in the order of evaluation
+	Values []Expr // Values are the compiled children, in the same order as Steps
+	Cycles string // Cycles describes the circular references in children
+	loc    Path   // loc is the location that the order is computed for
+}
+
+func (b Block) String() string { return b.Expr.String() }
+func (b Block) Type() ExprType { return b.Expr.Type() }
+
+// arity is the number of arguments a builtin function accepts, max is -1 if
+// it's unlimited.
+type arity struct{ min, max int }
+
+func (a arity) accepts(n int) bool {
+	return n >= a.min && (a.max < 0 || n <= a.max)
+}
+
+func (a arity) String() string {
+	switch {
+	case a.max < 0:
+		return fmt.Sprintf("at least %d", a.min)
+	case a.max == a.min:
+		return fmt.Sprint(a.min)
+	default:
+		return fmt.Sprintf("%d or %d", a.min, a.max)
+	}
+}
+
+var builtinArity = map[string]arity{
+	"+": {1, -1}, "-": {1, -1}, "*": {1, -1}, "/": {1, -1}, "round": {1, 2},
+	"and": {1, -1}, "or": {1, -1}, "not": {1, 1}, "==": {2, -1}, "!=": {2, -1},
+	">": {2, -1}, "<": {2, -1}, ">=": {2, -1}, "<=": {2, -1},
+	"if": {2, 3}, "cond": {1, -1}, "let": {2, 2}, "do": {1, -1}, "apply": {2, 2},
+	"map": {2, 2}, "filter": {2, 2}, "reduce": {2, 3}, "some": {2, 2}, "every": {2, 2}, "find": {2, 2},
+	"len": {1, 1}, "split": {2, 2}, "join": {2, 2}, "upper": {1, 1}, "lower": {1, 1},
+	"trim": {1, 2}, "substr": {2, 3}, "starts-with": {2, 2}, "ends-with": {2, 2},
+	"contains": {2, 2}, "replace": {3, 3}, "pad": {2, 3},
+	"keys": {1, 1}, "values": {1, 1}, "get": {2, 3}, "merge": {1, -1}, "deep-merge": {1, -1},
+	"pick": {2, 2}, "omit": {2, 2}, "entries": {1, 1}, "from-entries": {1, 1}, "set": {3, 3},
+}
+
+// Compile analyzes expr once for evaluation, the result is immutable and can
+// be evaluated many times. Objects and arrays are compiled into blocks with
+// their evaluation order, and function calls are resolved against functions,
+// unless the name may be defined in rule. The arity of builtin calls is
+// checked.
+func Compile(expr Expr, functions []Expr) (Expr, error) {
+	return newCompiler(expr, functions).compile(expr, Path{}, Path{})
+}
+
+type compiler struct {
+	functions []Expr
+	defined   map[Step]struct{} // defined are the keys in rule, which may be functions
+}
+
+func newCompiler(expr Expr, functions []Expr) *compiler {
+	c := &compiler{functions: functions, defined: map[Step]struct{}{}}
+	c.collectKeys(expr)
+	return c
+}
+
+func (c *compiler) collectKeys(expr Expr) {
+	switch expr := expr.(type) {
+	case Object:
+		for k, v := range expr.All() {
+			c.defined[StringStep(k)] = struct{}{}
+			c.collectKeys(v)
+		}
+	case Array:
+		for i, v := range expr {
+			c.defined[NumberStep(i)] = struct{}{}
+			c.collectKeys(v)
+		}
+	case FnCall:
+		for _, arg := range expr.Args {
+			c.collectKeys(arg)
+		}
+	case TenpenFn:
+		c.collectKeys(expr.Body)
+	}
+}
+
+// compile compiles expr which will be evaluated at loc, at is the location in
+// rule for error reporting.
+func (c *compiler) compile(expr Expr, loc Path, at Path) (Expr, error) {
+	switch expr := expr.(type) {
+	case Object:
+		return c.compileBlock(expr, objectChildren(expr), loc, at)
+	case Array:
+		return c.compileBlock(expr, arrayChildren(expr), loc, at)
+	case FnCall:
+		return c.compileFnCall(expr, loc, at)
+	case TenpenFn:
+		// the body is evaluated by a sub evaluator
+		body, err := c.compile(expr.Body, Path{}, at)
+		if err != nil {
+			return nil, err
+		}
+		return TenpenFn{Args: expr.Args, Body: body}, nil
+	default:
+		return expr, nil
+	}
+}
+
+func (c *compiler) compileBlock(expr Expr, children map[Step]Expr, loc Path, at Path) (Expr, error) {
+	order, cycles := evalOrder(childrenDeps(children, loc))
+	b := Block{
+		Expr:   expr,
+		Steps:  order,
+		Values: make([]Expr, 0, len(order)),
+		loc:    slices.Clone(loc),
+	}
+	if len(cycles) > 0 {
+		b.Cycles = circularRefError(cycles).Detail
+	}
+	for _, step := range order {
+		v, err := c.compile(children[step], append(loc, step), append(at, step))
+		if err != nil {
+			return nil, err
+		}
+		b.Values = append(b.Values, v)
+	}
+	return b, nil
+}
+
+func (c *compiler) compileFnCall(call FnCall, loc Path, at Path) (Expr, error) {
+	fn, builtin := c.resolve(call.FnRef)
+	if builtin != "" {
+		if a, ok := builtinArity[builtin]; ok && !a.accepts(len(call.Args)) {
+			err := inFn(arityError(a, len(call.Args)), call.FnRef)
+			return nil, locate(err, at, call.String())
+		}
+	}
+	if err := checkCallArity(fn, len(call.Args)); err != nil {
+		return nil, locate(inFn(err, call.FnRef), at, call.String())
+	}
+	args := make([]Expr, 0, len(call.Args))
+	for i, arg := range call.Args {
+		v, err := c.compile(arg, argLoc(fn, builtin, loc, i), append(at, NumberStep(i)))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return FnCall{FnRef: call.FnRef, Args: args, Fn: fn}, nil
+}
+
+// argLoc returns the location where the argument i of a call of fn at loc is
+// evaluated, builtin is the name of fn if it's a builtin.
+func argLoc(fn Fn, builtin string, loc Path, i int) Path {
+	if builtin == "let" {
+		return Path{} // evaluated by sub evaluators
+	}
+	if _, ok := fn.(Form); ok {
+		return loc // a form evaluates its arguments at the location of call
+	}
+	return append(loc, NumberStep(i))
+}
+
+// resolve finds the function of ref in functions, and returns the name if it's
+// a builtin. A function whose name is a key in rule is resolved at runtime.
+func (c *compiler) resolve(ref FnRef) (Fn, string) {
+	if _, ok := c.defined[ref[0]]; ok {
+		return nil, ""
+	}
+	for i := len(c.functions) - 1; i >= 0; i-- {
+		if v, err := Path(ref).GetFrom(c.functions[i]); err == nil && v.Type() == ExprFn {
+			// the first layer is builtins
+			if i == 0 && len(ref) == 1 {
+				return v.(Fn), ref[0].String()
+			}
+			return v.(Fn), ""
+		}
+	}
+	return nil, ""
+}
+
+// evalBlock evaluates a compiled block. If it's evaluated at a location other
+// than the one it's compiled for, the order may be different, so the original
+// expression is evaluated.
+func (e *Evaluator) evalBlock(b Block, loc Path) (Expr, error) {
+	if !slices.Equal(b.loc, loc) {
+		if obj, ok := b.Expr.(Object); ok {
+			return e.evalObject(obj, loc)
+		}
+		return e.evalArray(b.Expr.(Array), loc)
+	}
+	var container Expr
+	switch expr := b.Expr.(type) {
+	case Object:
+		container = resultObject(expr)
+	case Array:
+		container = make(Array, len(expr))
+	}
+	if err := e.setVal(loc, container); err != nil {
+		return nil, err
+	}
+	for i, step := range b.Steps {
+		if err := e.evalChild(b.Values[i], loc, step); err != nil {
+			return nil, err
+		}
+	}
+	if b.Cycles != "" {
+		return nil, tperr.CircularRefError().WithDetail("%s", b.Cycles)
+	}
+	return e.getVal(loc)
+}
+
+// Project returns the object rule with only keys and the keys they depend on,
+// so the others are not evaluated. The keys are in the order of rule.
+func Project(rule Expr, keys []string) (Object, error) {
+	obj, ok := rule.(Object)
+	if !ok {
+		return Object{}, tperr.InvalidTypeError().WithDetail("expects object rule, got %s", rule.Type())
+	}
+	deps := childrenDeps(objectChildren(obj), Path{})
+	needed := map[Step]struct{}{}
+	var need func(step Step)
+	need = func(step Step) {
+		if _, ok := needed[step]; ok {
+			return
+		}
+		needed[step] = struct{}{}
+		for d := range deps[step] {
+			need(d)
+		}
+	}
+	for _, k := range keys {
+		if _, ok := obj.Get(k); !ok {
+			return Object{}, tperr.NoRefError().WithDetail("%s is not found", ValRef{StringStep(k)})
+		}
+		need(StringStep(k))
+	}
+	projected := NewObject(len(needed))
+	for k, v := range obj.All() {
+		if _, ok := needed[StringStep(k)]; ok {
+			projected.Set(k, v)
+		}
+	}
+	return projected, nil
+}
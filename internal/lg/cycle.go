@@ -0,0 +1,159 @@
+package lg
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// findCycles finds the strongly connected components of deps by Tarjan's
+// algorithm, and returns a cycle in each component, like [a, b, c, a].
+func findCycles(deps map[Step]map[Step]struct{}) [][]Step {
+	nodes := sortedSteps(deps)
+	var (
+		index   = map[Step]int{}
+		lowlink = map[Step]int{}
+		onStack = map[Step]bool{}
+		stack   []Step
+		cycles  [][]Step
+	)
+	var connect func(v Step)
+	connect = func(v Step) {
+		index[v] = len(index)
+		lowlink[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range sortedSteps(deps[v]) {
+			if _, ok := index[w]; !ok {
+				connect(w)
+				lowlink[v] = min(lowlink[v], lowlink[w])
+			} else if onStack[w] {
+				lowlink[v] = min(lowlink[v], index[w])
+			}
+		}
+		if lowlink[v] != index[v] {
+			return
+		}
+		component := map[Step]struct{}{}
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			component[w] = struct{}{}
+			if w == v {
+				break
+			}
+		}
+		if _, self := deps[v][v]; len(component) > 1 || self {
+			cycles = append(cycles, cycleIn(deps, component))
+		}
+	}
+	for _, v := range nodes {
+		if _, ok := index[v]; !ok {
+			connect(v)
+		}
+	}
+	slices.SortFunc(cycles, func(a, b []Step) int {
+		return compareSteps(a[0], b[0])
+	})
+	return cycles
+}
+
+// cycleIn walks from the smallest node of a strongly connected component until
+// a node is visited again, and returns the path of the loop.
+func cycleIn(deps map[Step]map[Step]struct{}, component map[Step]struct{}) []Step {
+	start := sortedSteps(component)[0]
+	path := []Step{start}
+	visited := map[Step]int{start: 0}
+	for v := start; ; {
+		for _, w := range sortedSteps(deps[v]) {
+			if _, ok := component[w]; ok {
+				v = w
+				break
+			}
+		}
+		if i, ok := visited[v]; ok {
+			return append(path[i:], v)
+		}
+		visited[v] = len(path)
+		path = append(path, v)
+	}
+}
+
+func sortedSteps[V any](m map[Step]V) []Step {
+	steps := make([]Step, 0, len(m))
+	for s := range m {
+		steps = append(steps, s)
+	}
+	slices.SortFunc(steps, compareSteps)
+	return steps
+}
+
+func compareSteps(a, b Step) int {
+	an, ok1 := a.(NumberStep)
+	bn, ok2 := b.(NumberStep)
+	if ok1 && ok2 {
+		return int(an - bn)
+	}
+	return strings.Compare(a.String(), b.String())
+}
+
+func formatCycle(cycle []Step) string {
+	names := make([]string, 0, len(cycle))
+	for _, s := range cycle {
+		names = append(names, s.String())
+	}
+	return strings.Join(names, " -> ")
+}
+
+func circularRefError(deps map[Step]map[Step]struct{}) *tperr.Error {
+	cycles := findCycles(deps)
+	details := make([]string, 0, len(cycles))
+	for _, c := range cycles {
+		details = append(details, formatCycle(c))
+	}
+	return tperr.CircularRefError().WithDetail("%s", strings.Join(details, "; "))
+}
+
+// CheckCycles finds all the circular references in expr without evaluating
+// it, functions are the layers of functions to resolve calls, like Compile.
+// Every cycle is reported as an error at the location of its parent.
+func CheckCycles(expr Expr, functions []Expr) error {
+	var errs []error
+	newCompiler(expr, functions).checkCycles(expr, Path{}, Path{}, &errs)
+	return errors.Join(errs...)
+}
+
+// checkCycles walks expr, loc is the location used to resolve the siblings,
+// which is the same as the evaluator's, and at is the location in rule.
+func (c *compiler) checkCycles(expr Expr, loc Path, at Path, errs *[]error) {
+	var children map[Step]Expr
+	switch expr := expr.(type) {
+	case Object:
+		children = objectChildren(expr)
+	case Array:
+		children = arrayChildren(expr)
+	case FnCall:
+		fn, builtin := c.resolve(expr.FnRef)
+		for i, arg := range expr.Args {
+			c.checkCycles(arg, argLoc(fn, builtin, loc, i), append(at, NumberStep(i)), errs)
+		}
+		return
+	case TenpenFn:
+		c.checkCycles(expr.Body, Path{}, at, errs)
+		return
+	default:
+		return
+	}
+	for _, cycle := range findCycles(childrenDeps(children, loc)) {
+		err := tperr.CircularRefError().WithDetail("%s", formatCycle(cycle))
+		err.Location = at.String()
+		err.Expr = expr.String()
+		*errs = append(*errs, err)
+	}
+	for _, step := range sortedSteps(children) {
+		c.checkCycles(children[step], append(loc, step), append(at, step), errs)
+	}
+}
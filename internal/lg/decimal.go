@@ -0,0 +1,275 @@
+package lg
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// Decimal is an exact decimal number, its value is coef * 10^-scale. The scale
+// is kept, so 12.50 is serialized as 12.50. It's immutable.
+type Decimal struct {
+	coef  *big.Int
+	scale int32
+}
+
+// NewDecimal returns coef * 10^-scale.
+func NewDecimal(coef int64, scale int32) Decimal {
+	return Decimal{coef: big.NewInt(coef), scale: scale}
+}
+
+// maxExponent is the max exponent of a parsed decimal, to keep it in memory.
+const maxExponent = 10000
+
+// ParseDecimal parses a json number, like "12.50", "-3" or "1.5e3".
+func ParseDecimal(s string) (Decimal, error) {
+	invalid := tperr.InvalidArgError().WithDetail("%q is not a decimal", s)
+	mant, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(strings.TrimPrefix(s[i+1:], "+"))
+		if err != nil || e > maxExponent || e < -maxExponent {
+			return Decimal{}, invalid
+		}
+		mant, exp = s[:i], e
+	}
+	intPart, frac, _ := strings.Cut(mant, ".")
+	digits := strings.TrimPrefix(strings.TrimPrefix(intPart, "-"), "+") + frac
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return Decimal{}, invalid
+	}
+	coef, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Decimal{}, invalid
+	}
+	if strings.HasPrefix(intPart, "-") {
+		coef.Neg(coef)
+	}
+	scale := len(frac) - exp
+	if scale < 0 {
+		coef.Mul(coef, pow10(-scale))
+		scale = 0
+	}
+	if scale > math.MaxInt32 {
+		return Decimal{}, invalid
+	}
+	return Decimal{coef: coef, scale: int32(scale)}, nil
+}
+
+// DecimalFromFloat returns the shortest decimal that converts back to f.
+func DecimalFromFloat(f float64) (Decimal, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return Decimal{}, tperr.InvalidArgError().WithDetail("can't convert %v to decimal", f)
+	}
+	return ParseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+func (d Decimal) String() string {
+	return fmt.Sprintf("decimal<%s>", d.Text())
+}
+
+func (d Decimal) Type() ExprType {
+	return ExprNumber
+}
+
+func (d Decimal) c() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+// Text formats d with its scale, like "12.50".
+func (d Decimal) Text() string {
+	c := d.c()
+	s := new(big.Int).Abs(c).String()
+	if d.scale > 0 {
+		if len(s) <= int(d.scale) {
+			s = strings.Repeat("0", int(d.scale)-len(s)+1) + s
+		}
+		s = s[:len(s)-int(d.scale)] + "." + s[len(s)-int(d.scale):]
+	}
+	if c.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// Scale returns the number of digits after the decimal point.
+func (d Decimal) Scale() int {
+	return int(d.scale)
+}
+
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.Text(), 64)
+	return f
+}
+
+// Int64 returns d as an integer, ok is false if d isn't an integer in range.
+func (d Decimal) Int64() (n int64, ok bool) {
+	q, r := new(big.Int).QuoRem(d.c(), pow10(int(d.scale)), new(big.Int))
+	if r.Sign() != 0 || !q.IsInt64() {
+		return 0, false
+	}
+	return q.Int64(), true
+}
+
+// uint64 returns d as an unsigned integer, ok is false if d isn't an unsigned
+// integer in range.
+func (d Decimal) uint64() (n uint64, ok bool) {
+	q, r := new(big.Int).QuoRem(d.c(), pow10(int(d.scale)), new(big.Int))
+	if r.Sign() != 0 || !q.IsUint64() {
+		return 0, false
+	}
+	return q.Uint64(), true
+}
+
+func (d Decimal) Sign() int {
+	return d.c().Sign()
+}
+
+// rescale returns the coefficient of d at a scale not less than d's.
+func (d Decimal) rescale(scale int32) *big.Int {
+	if scale == d.scale {
+		return d.c()
+	}
+	return new(big.Int).Mul(d.c(), pow10(int(scale-d.scale)))
+}
+
+func (d Decimal) Cmp(y Decimal) int {
+	scale := max(d.scale, y.scale)
+	return d.rescale(scale).Cmp(y.rescale(scale))
+}
+
+func (d Decimal) Add(y Decimal) Decimal {
+	scale := max(d.scale, y.scale)
+	return Decimal{coef: new(big.Int).Add(d.rescale(scale), y.rescale(scale)), scale: scale}
+}
+
+func (d Decimal) Sub(y Decimal) Decimal {
+	scale := max(d.scale, y.scale)
+	return Decimal{coef: new(big.Int).Sub(d.rescale(scale), y.rescale(scale)), scale: scale}
+}
+
+func (d Decimal) Mul(y Decimal) Decimal {
+	return Decimal{coef: new(big.Int).Mul(d.c(), y.c()), scale: d.scale + y.scale}
+}
+
+// Quo returns d / y. An exact quotient keeps the scale of d minus the scale of
+// y, the others are rounded at scale by rounding.
+func (d Decimal) Quo(y Decimal, scale int, rounding Rounding) (Decimal, error) {
+	if y.Sign() == 0 {
+		return Decimal{}, tperr.InvalidArgError().WithDetail("division by zero")
+	}
+	preferred := max(int(d.scale)-int(y.scale), 0)
+	scale = max(scale, preferred)
+	// d / y = (d.coef * 10^(scale - d.scale + y.scale) / y.coef) * 10^-scale
+	num := new(big.Int).Mul(d.c(), pow10(scale-int(d.scale)+int(y.scale)))
+	q, r := new(big.Int).QuoRem(num, y.c(), new(big.Int))
+	if r.Sign() != 0 {
+		return Decimal{coef: roundQuo(q, r, y.c(), rounding), scale: int32(scale)}, nil
+	}
+	// strip the trailing zeros of an exact quotient
+	ten := big.NewInt(10)
+	for scale > preferred {
+		q2, r2 := new(big.Int).QuoRem(q, ten, new(big.Int))
+		if r2.Sign() != 0 {
+			break
+		}
+		q, scale = q2, scale-1
+	}
+	return Decimal{coef: q, scale: int32(scale)}, nil
+}
+
+// Round returns d rounded at scale by rounding, the result has the scale. A
+// negative scale rounds d to tens, hundreds and so on, and the result is an
+// integer.
+func (d Decimal) Round(scale int, rounding Rounding) Decimal {
+	if scale >= int(d.scale) {
+		return Decimal{coef: d.rescale(int32(scale)), scale: int32(scale)}
+	}
+	den := pow10(int(d.scale) - scale)
+	q, r := new(big.Int).QuoRem(d.c(), den, new(big.Int))
+	q = roundQuo(q, r, den, rounding)
+	if scale < 0 {
+		return Decimal{coef: q.Mul(q, pow10(-scale))}
+	}
+	return Decimal{coef: q, scale: int32(scale)}
+}
+
+// Rounding is the mode to round a decimal.
+type Rounding int
+
+const (
+	RoundHalfEven Rounding = iota // RoundHalfEven rounds to the nearest, ties to even
+	RoundHalfUp                   // RoundHalfUp rounds to the nearest, ties away from zero
+	RoundHalfDown                 // RoundHalfDown rounds to the nearest, ties toward zero
+	RoundUp                       // RoundUp rounds away from zero
+	RoundDown                     // RoundDown rounds toward zero
+	RoundCeiling                  // RoundCeiling rounds toward positive infinity
+	RoundFloor                    // RoundFloor rounds toward negative infinity
+)
+
+// roundQuo rounds q, the truncated quotient with remainder r of den.
+func roundQuo(q, r, den *big.Int, rounding Rounding) *big.Int {
+	if r.Sign() == 0 {
+		return q
+	}
+	negative := r.Sign()*den.Sign() < 0
+	// half compares the remainder with a half of den
+	twice := new(big.Int).Abs(r)
+	half := twice.Lsh(twice, 1).Cmp(new(big.Int).Abs(den))
+	var away bool
+	switch rounding {
+	case RoundHalfEven:
+		away = half > 0 || half == 0 && q.Bit(0) == 1
+	case RoundHalfUp:
+		away = half >= 0
+	case RoundHalfDown:
+		away = half > 0
+	case RoundUp:
+		away = true
+	case RoundDown:
+		away = false
+	case RoundCeiling:
+		away = !negative
+	case RoundFloor:
+		away = negative
+	}
+	if !away {
+		return q
+	}
+	if negative {
+		return q.Sub(q, big.NewInt(1))
+	}
+	return q.Add(q, big.NewInt(1))
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// DecimalOptions are the options of decimal mode, numbers in rule and facts are
+// parsed as decimals in it.
+type DecimalOptions struct {
+	DivScale int      // DivScale is the scale of an inexact quotient, 16 if zero
+	Rounding Rounding // Rounding is the mode to round quotients and $round
+}
+
+func (o DecimalOptions) divScale() int {
+	if o.DivScale == 0 {
+		return 16
+	}
+	return o.DivScale
+}
+
+// decimalOptions returns the decimal options of evaluator.
+func decimalOptions(e Evaller) DecimalOptions {
+	if ev, ok := e.(*Evaluator); ok {
+		return ev.decimal
+	}
+	return DecimalOptions{}
+}
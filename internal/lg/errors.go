@@ -0,0 +1,66 @@
+package lg
+
+import (
+	"errors"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+func typeError(want string, got Expr) *tperr.Error {
+	return tperr.InvalidTypeError().WithDetail("expects %s, got %s", want, got.Type())
+}
+
+func arityError(want any, got int) *tperr.Error {
+	return tperr.InvalidArgError().WithDetail("expects %v arguments, got %d", want, got)
+}
+
+// checkCallArity checks the number of arguments of a call of fn in rule, a
+// function defined by $def takes exactly its parameters. The higher-order
+// functions apply it with fewer or more arguments, like the index of an
+// element, so it's not checked by TenpenFn.Apply.
+func checkCallArity(fn Fn, n int) error {
+	if f, ok := fn.(TenpenFn); ok && len(f.Args) != n {
+		return arityError(len(f.Args), n)
+	}
+	return nil
+}
+
+// as converts arg to T, or returns an InvalidType error.
+func as[T Expr](arg Expr) (T, error) {
+	v, ok := arg.(T)
+	if !ok {
+		var zero T
+		want := "function"
+		if e, ok := any(zero).(Expr); ok {
+			want = e.Type().String()
+		}
+		return zero, typeError(want, arg)
+	}
+	return v, nil
+}
+
+// inFn fills the function of err if it's not set.
+func inFn(err error, ref FnRef) error {
+	if err == nil {
+		return nil
+	}
+	var te *tperr.Error
+	if errors.As(err, &te) && te.Fn == "" {
+		te.Fn = ref.String()
+	}
+	return err
+}
+
+// locate fills the location and expression of err if they are not set, so the
+// innermost location is kept.
+func locate(err error, loc Path, expr string) error {
+	if err == nil {
+		return nil
+	}
+	var te *tperr.Error
+	if errors.As(err, &te) && te.Location == "" {
+		te.Location = loc.String()
+		te.Expr = expr
+	}
+	return err
+}
@@ -1,198 +1,386 @@
 package lg
 
 import (
+	"context"
+	"slices"
+
 	"github.com/nanozuki/tenpen/tperr"
 )
 
+// Evaluator evaluates a rule once, it's not safe for concurrent use. Every
+// evaluation creates its own Evaluator, the rule, environments and functions
+// are never modified by it.
 type Evaluator struct {
-	rule Expr
-	v    []Expr // v is the stack of values, last one is the runtime value
-	f    []Expr // f is the stack of functions, last one is the runtime functions
+	ctx     context.Context
+	meter   *meter
+	facts   *facts // facts are got from the fact source, it's nil if no source
+	decimal DecimalOptions
+	rule    Expr
+	v       []Expr // v is the stack of values, last one is the runtime value
+	f       []Expr // f is the stack of functions, last one is the runtime functions
+	loc     Path   // loc is the location that Eval evaluates at
+	depth   int    // depth is the nesting depth of sub evaluators
+	envs    int    // envs is the number of environments at the bottom of v
+	trace   *tracer
 }
 
-func NewEvaluator(rule Expr, vars []Expr, functions []Expr) *Evaluator {
-	e := &Evaluator{
-		rule: rule,
-		v:    vars,
-		f:    functions,
-	}
-	switch rule.Type() {
-	case ExprArray:
-		e.v = append(e.v, Array{})
-		e.f = append(e.f, Array{})
-	case ExprObject:
-		e.v = append(e.v, Object{})
-		e.f = append(e.f, Object{})
+func NewEvaluator(ctx context.Context, rule Expr, vars []Expr, functions []Expr, limits Limits) *Evaluator {
+	// the last layer of v and f is for the values and functions defined in rule
+	v := make([]Expr, 0, len(vars)+1)
+	v = append(v, vars...)
+	f := make([]Expr, 0, len(functions)+1)
+	f = append(f, functions...)
+	return &Evaluator{
+		ctx:   ctx,
+		meter: &meter{limits: limits},
+		rule:  rule,
+		v:     append(v, Null{}),
+		f:     append(f, Null{}),
+		envs:  len(vars),
 	}
+}
+
+// WithSource sets the fact source that is consulted after the environments.
+func (e *Evaluator) WithSource(source FactSource) *Evaluator {
+	e.facts = &facts{source: source, got: map[string]fact{}}
+	return e
+}
+
+// WithDecimal sets the options of decimal arithmetic.
+func (e *Evaluator) WithDecimal(opts DecimalOptions) *Evaluator {
+	e.decimal = opts
 	return e
 }
 
 func (e *Evaluator) setVal(loc Path, value Expr) error {
-	if len(loc) == 0 {
-		e.v[len(e.v)-1] = value
+	v, err := loc.SetTo(e.v[len(e.v)-1], value)
+	if err != nil {
+		return err
+	}
+	e.v[len(e.v)-1] = v
+	return nil
+}
+
+// store saves the evaluated result at loc. A function is saved to the function
+// stack, and it's value is null, which is hidden from the output.
+func (e *Evaluator) store(loc Path, result Expr) error {
+	fn, isFn := result.(Fn)
+	if isFn {
+		if err := e.setFn(loc, fn); err != nil {
+			return err
+		}
+		result = Null{}
+	}
+	if err := e.setVal(loc, result); err != nil {
+		return err
+	}
+	if !isFn || len(loc) == 0 {
 		return nil
 	}
-	return loc.SetTo(e.v[len(e.v)-1], value)
+	if key, ok := loc[len(loc)-1].(StringStep); ok {
+		parent, err := loc[:len(loc)-1].GetFrom(e.v[len(e.v)-1])
+		if obj, isObj := parent.(Object); err == nil && isObj {
+			obj.hide(string(key))
+		}
+	}
+	return nil
 }
 
 func (e *Evaluator) getVal(loc Path) (Expr, error) {
+	v, _, err := e.lookup(loc)
+	return v, err
+}
+
+// lookup is like getVal, and returns the index of the layer in v where the
+// value is found, or -1 if it's got from the fact source.
+func (e *Evaluator) lookup(loc Path) (Expr, int, error) {
 	for i := len(e.v) - 1; i >= 0; i-- {
-		if v, err := loc.GetFrom(e.v[i]); err == nil {
-			return v, nil
+		if v, ok := loc.find(e.v[i]); ok {
+			return v, i, nil
 		}
 	}
-	return nil, tperr.NoRefError()
+	if e.facts != nil {
+		v, ok, err := e.facts.get(e.ctx, loc)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			return v, -1, nil
+		}
+	}
+	return nil, 0, tperr.NoRefError().WithDetail("%s is not found", ValRef(loc))
 }
 
 func (e *Evaluator) setFn(loc Path, value Fn) error {
-	if len(loc) == 0 {
-		e.f[len(e.f)-1] = value
-		return nil
+	f, err := loc.SetTo(e.f[len(e.f)-1], value)
+	if err != nil {
+		return err
 	}
-	return loc.SetTo(e.f[len(e.f)-1], value)
+	e.f[len(e.f)-1] = f
+	return nil
 }
 
+// getFn finds the function at loc. The error is attributed to the missing
+// function, so it's not blamed on the function that calls it.
 func (e *Evaluator) getFn(loc Path) (Fn, error) {
 	for i := len(e.f) - 1; i >= 0; i-- {
-		if v, err := loc.GetFrom(e.f[i]); err == nil && v.Type() == ExprFn {
+		if v, ok := loc.find(e.f[i]); ok && v.Type() == ExprFn {
 			return v.(Fn), nil
 		}
 	}
-	return nil, tperr.NoRefError()
+	err := tperr.NoRefError().WithDetail("is not found")
+	err.Fn = FnRef(loc).String()
+	return nil, err
 }
 
+// SubEvaller returns an evaluator with a new scope. The stacks are copied, so
+// the sub evaluators never share the backing arrays of their stacks. Both are
+// cut from one array, a call of function allocates it once.
 func (e *Evaluator) SubEvaller(scopedValue Expr) Evaller {
+	nv, nf := len(e.v)+2, len(e.f)+1
+	stacks := make([]Expr, 0, nv+nf)
+	v := append(stacks[:0:nv], e.v...)
+	f := append(stacks[nv:nv:nv+nf], e.f...)
 	return &Evaluator{
-		rule: e.rule,
-		v:    append(e.v, scopedValue, Null{}),
-		f:    append(e.f, Null{}),
+		ctx:     e.ctx,
+		meter:   e.meter,
+		facts:   e.facts,
+		decimal: e.decimal,
+		rule:    e.rule,
+		v:       append(v, scopedValue, Null{}),
+		f:       append(f, Null{}),
+		depth:   e.depth + 1,
+		envs:    e.envs,
+		trace:   e.trace,
 	}
 }
 
+// Defined returns the values and functions defined by the rule, they are the
+// last layers of the stacks, or null if nothing is defined.
+func (e *Evaluator) Defined() (vals Expr, funs Expr) {
+	return e.v[len(e.v)-1], e.f[len(e.f)-1]
+}
+
+func (e *Evaluator) Context() context.Context {
+	return e.ctx
+}
+
 func (e *Evaluator) Eval(expr Expr) (Expr, error) {
-	return e.eval(expr, []Step{})
+	return e.eval(expr, e.loc)
+}
+
+// at returns an evaluator that shares the stacks with e, but evaluates at loc.
+func (e *Evaluator) at(loc Path) *Evaluator {
+	sub := *e
+	sub.loc = loc
+	return &sub
 }
 
 func (e *Evaluator) eval(expr Expr, loc Path) (Expr, error) {
+	if p, ok := expr.(*Program); ok {
+		if slices.Equal(p.loc, loc) {
+			return e.run(p)
+		}
+		expr = p.Expr
+	}
+	var step *Trace
+	if e.trace != nil {
+		step = e.trace.begin(loc, expr)
+	}
+	v, err := e.evalExpr(expr, loc)
+	if err == nil && expr.Type() != ExprValRef { // facts are not counted
+		err = e.meter.checkSize(v)
+	}
+	if err != nil {
+		v, err = nil, locate(err, loc, expr.String())
+	}
+	if step != nil {
+		e.trace.end(step, v, err)
+	}
+	return v, err
+}
+
+// enter checks the context and counts a step before evaluating an expression.
+func (e *Evaluator) enter() error {
+	if err := e.ctx.Err(); err != nil {
+		return tperr.CanceledError(err)
+	}
+	return e.meter.step(e.depth)
+}
+
+func (e *Evaluator) evalExpr(expr Expr, loc Path) (Expr, error) {
+	if err := e.enter(); err != nil {
+		return nil, err
+	}
 	switch expr := expr.(type) {
-	case Null, String, Number, Bool:
+	case Null, String, Number, Int, Decimal, Bool:
 		return expr, nil
 	case Object:
 		return e.evalObject(expr, loc)
 	case Array:
 		return e.evalArray(expr, loc)
 	case ValRef:
+		if e.trace != nil {
+			return e.traceVal(Path(expr))
+		}
 		return e.getVal(Path(expr))
 	case FnRef:
-		// FnRef cannot be evaluated directly
-		return nil, tperr.InvalidTypeError()
+		return e.getFn(Path(expr))
 	case Fn:
-		err := e.setFn(loc, expr)
-		return Null{}, err
+		return expr, nil
 	case FnCall:
-		return e.evalFnCall(expr, expr.Args, loc)
+		return e.evalFnCall(expr, loc)
+	case Block:
+		return e.evalBlock(expr, loc)
 	default:
 		panic("unreachable")
 	}
 }
 
 func (e *Evaluator) evalObject(obj Object, loc Path) (Expr, error) {
-	deps := make(map[string]map[Step]struct{})
-	var keys []string
-	for key, expr := range obj {
-		deps[key] = make(map[Step]struct{})
-		makeExprDeps(deps[key], expr, loc)
-		keys = append(keys, key)
-	}
-	for len(keys) > 0 {
-		var remains []string
-		for _, key := range keys {
-			if len(deps[key]) == 0 {
-				result, err := e.eval(obj[key], append(loc, StringStep(key)))
-				if err != nil {
-					return nil, err
-				}
-				if err := e.setVal(append(loc, StringStep(key)), result); err != nil {
-					return nil, err
-				}
-				for k := range deps {
-					delete(deps[k], StringStep(key))
-				}
-				delete(deps, key)
-			} else {
-				remains = append(remains, key)
-			}
-		}
-		if len(remains) == len(keys) {
-			return nil, tperr.CircularRefError()
-		}
-		keys = remains
+	if err := e.setVal(loc, resultObject(obj)); err != nil {
+		return nil, err
+	}
+	if err := e.evalChildren(objectChildren(obj), loc); err != nil {
+		return nil, err
 	}
 	return e.getVal(loc)
 }
 
 func (e *Evaluator) evalArray(arr Array, loc Path) (Expr, error) {
-	deps := make(map[int]map[Step]struct{})
-	var indices []int
+	if err := e.setVal(loc, make(Array, len(arr))); err != nil {
+		return nil, err
+	}
+	if err := e.evalChildren(arrayChildren(arr), loc); err != nil {
+		return nil, err
+	}
+	return e.getVal(loc)
+}
+
+func objectChildren(obj Object) map[Step]Expr {
+	children := make(map[Step]Expr, obj.Len())
+	for key, expr := range obj.All() {
+		children[StringStep(key)] = expr
+	}
+	return children
+}
+
+func arrayChildren(arr Array) map[Step]Expr {
+	children := make(map[Step]Expr, len(arr))
 	for i, expr := range arr {
-		deps[i] = make(map[Step]struct{})
-		makeExprDeps(deps[i], expr, loc)
-		indices = append(indices, i)
-	}
-	for len(indices) > 0 {
-		var remains []int
-		for _, i := range indices {
-			if len(deps[i]) == 0 {
-				result, err := e.eval(arr[i], append(loc, NumberStep(i)))
-				if err != nil {
-					return nil, err
-				}
-				if err := e.setVal(append(loc, NumberStep(i)), result); err != nil {
-					return nil, err
-				}
-				for k := range deps {
-					delete(deps[k], NumberStep(i))
-				}
-				delete(deps, i)
-			} else {
-				remains = append(remains, i)
+		children[NumberStep(i)] = expr
+	}
+	return children
+}
+
+// evalChildren evaluates the children of an object or array at loc, a child
+// is evaluated after the siblings it depends on.
+func (e *Evaluator) evalChildren(children map[Step]Expr, loc Path) error {
+	order, cycles := evalOrder(childrenDeps(children, loc))
+	for _, step := range order {
+		if err := e.evalChild(children[step], loc, step); err != nil {
+			return err
+		}
+	}
+	if len(cycles) > 0 {
+		return circularRefError(cycles)
+	}
+	return nil
+}
+
+func (e *Evaluator) evalChild(expr Expr, loc Path, step Step) error {
+	result, err := e.eval(expr, append(loc, step))
+	if err != nil {
+		return err
+	}
+	return e.store(append(loc, step), result)
+}
+
+// evalOrder sorts the steps by deps, a step comes after the steps it depends
+// on. The steps can't be sorted are returned in remains with their deps, they
+// form cycles.
+func evalOrder(deps map[Step]map[Step]struct{}) (order []Step, remains map[Step]map[Step]struct{}) {
+	for len(deps) > 0 {
+		var ready []Step
+		for _, step := range sortedSteps(deps) {
+			if len(deps[step]) == 0 {
+				ready = append(ready, step)
 			}
 		}
-		if len(remains) == len(indices) {
-			return nil, tperr.CircularRefError()
+		if len(ready) == 0 {
+			return order, deps
 		}
-		indices = remains
+		for _, step := range ready {
+			delete(deps, step)
+			for k := range deps {
+				delete(deps[k], step)
+			}
+		}
+		order = append(order, ready...)
 	}
-	return e.getVal(loc)
+	return order, nil
+}
+
+// childrenDeps returns the dependencies between the children at loc.
+func childrenDeps(children map[Step]Expr, loc Path) map[Step]map[Step]struct{} {
+	deps := make(map[Step]map[Step]struct{}, len(children))
+	for step, expr := range children {
+		deps[step] = make(map[Step]struct{})
+		makeExprDeps(deps[step], expr, loc, true)
+		for d := range deps[step] {
+			// only siblings are dependencies, others are from environments
+			if _, ok := children[d]; !ok {
+				delete(deps[step], d)
+			}
+		}
+		if _, ok := deps[step][step]; ok && expr.Type() == ExprFn {
+			delete(deps[step], step) // recursive function
+		} else if ok {
+			// calling a function of the same name refers to the outer one
+			vals := make(map[Step]struct{})
+			makeExprDeps(vals, expr, loc, false)
+			if _, ok := vals[step]; !ok {
+				delete(deps[step], step)
+			}
+		}
+	}
+	return deps
 }
 
-func makeExprDeps(deps map[Step]struct{}, expr Expr, parent Path) {
+// makeExprDeps collects the steps under parent that expr refers to, the
+// function references are included if withFn is true.
+func makeExprDeps(deps map[Step]struct{}, expr Expr, parent Path, withFn bool) {
 	switch expr := expr.(type) {
-	case Null, String, Number, Bool:
+	case Null, String, Number, Int, Decimal, Bool:
 		return
 	case Array:
 		for _, ex := range expr {
-			makeExprDeps(deps, ex, parent)
+			makeExprDeps(deps, ex, parent, withFn)
 		}
 	case Object:
-		for _, ex := range expr {
-			makeExprDeps(deps, ex, parent)
+		for _, ex := range expr.All() {
+			makeExprDeps(deps, ex, parent, withFn)
 		}
 	case ValRef:
 		if Path(expr).IsChildOf(parent) {
 			deps[expr[len(parent)]] = struct{}{}
 		}
 	case FnRef:
-		if Path(expr).IsChildOf(parent) {
+		if withFn && Path(expr).IsChildOf(parent) {
 			deps[expr[len(parent)]] = struct{}{}
 		}
 	case FnCall:
+		makeExprDeps(deps, expr.FnRef, parent, withFn)
 		for _, arg := range expr.Args {
-			makeExprDeps(deps, arg, parent)
+			makeExprDeps(deps, arg, parent, withFn)
 		}
+	case Block:
+		makeExprDeps(deps, expr.Expr, parent, withFn)
+	case *Program:
+		makeExprDeps(deps, expr.Expr, parent, withFn)
 	case TenpenFn:
 		dd := make(map[Step]struct{})
-		makeExprDeps(dd, expr.Body, parent)
+		makeExprDeps(dd, expr.Body, parent, withFn)
 		for _, arg := range expr.Args {
 			delete(dd, StringStep(arg))
 		}
@@ -202,37 +390,35 @@ func makeExprDeps(deps map[Step]struct{}, expr Expr, parent Path) {
 	}
 }
 
-func (e *Evaluator) evalFnCall(fnCall FnCall, args []Expr, loc Path) (Expr, error) {
-	fn, err := e.getFn(Path(fnCall.FnRef))
-	if err != nil {
-		return nil, err
-	}
-	switch fn := fn.(type) {
-	case GoFn:
-		var args []Expr
-		for i, arg := range fnCall.Args {
-			evaluated, err := e.eval(arg, append(loc, NumberStep(i)))
-			if err != nil {
-				return nil, err
-			}
-			args = append(args, evaluated)
+func (e *Evaluator) evalFnCall(fnCall FnCall, loc Path) (Expr, error) {
+	fn := fnCall.Fn
+	if fn == nil {
+		var err error
+		if fn, err = e.getFn(Path(fnCall.FnRef)); err != nil {
+			return nil, err
 		}
-		return fn(e, args)
-	case TenpenFn:
-		if len(args) < len(fn.Args) {
-			return nil, tperr.InvalidArgError()
-		}
-		e.v = append(e.v, Object{})
-		defer func() {
-			e.v = e.v[:len(e.v)-1]
-		}()
-		for i, argName := range fn.Args {
-			if err := e.setVal(append(loc, StringStep(argName)), args[i]); err != nil {
-				return nil, err
-			}
+	}
+	if e.trace != nil {
+		e.trace.current().Fn = fnCall.FnRef.String()
+	}
+	if form, ok := fn.(Form); ok {
+		v, err := form(e.at(loc), fnCall.Args)
+		return v, inFn(err, fnCall.FnRef)
+	}
+	args := make([]Expr, 0, len(fnCall.Args))
+	for i, arg := range fnCall.Args {
+		evaluated, err := e.eval(arg, append(loc, NumberStep(i)))
+		if err != nil {
+			return nil, err
 		}
-		return e.eval(fn.Body, loc)
-	default:
-		return nil, tperr.InvalidTypeError()
+		args = append(args, evaluated)
+	}
+	if e.trace != nil {
+		e.trace.current().Args = args
+	}
+	if err := checkCallArity(fn, len(args)); err != nil {
+		return nil, inFn(err, fnCall.FnRef)
 	}
+	v, err := fn.Apply(e.at(loc), args)
+	return v, inFn(err, fnCall.FnRef)
 }
@@ -1,6 +1,7 @@
 package lg
 
 import (
+	"context"
 	"fmt"
 	"strings"
 )
@@ -20,6 +21,33 @@ const (
 	ExprFn
 )
 
+func (t ExprType) String() string {
+	switch t {
+	case ExprNull:
+		return "null"
+	case ExprString:
+		return "string"
+	case ExprNumber:
+		return "number"
+	case ExprBool:
+		return "bool"
+	case ExprArray:
+		return "array"
+	case ExprObject:
+		return "object"
+	case ExprValRef:
+		return "value reference"
+	case ExprFnRef:
+		return "function reference"
+	case ExprFnCall:
+		return "function call"
+	case ExprFn:
+		return "function"
+	default:
+		return "unknown"
+	}
+}
+
 type Expr interface {
 	String() string
 	Type() ExprType
@@ -84,29 +112,6 @@ func (a Array) Type() ExprType {
 	return ExprArray
 }
 
-type Object map[string]Expr
-
-func (o Object) String() string {
-	b := strings.Builder{}
-	b.WriteByte('{')
-	i := 0
-	for k, v := range o {
-		if i > 0 {
-			b.WriteByte(',')
-		}
-		b.WriteByte('"')
-		b.WriteString(k)
-		b.WriteByte('"')
-		b.WriteByte(':')
-		b.WriteString(v.String())
-		i++
-	}
-	b.WriteByte('}')
-	return b.String()
-}
-
-func (o Object) Type() ExprType { return ExprObject }
-
 type ValRef Path
 
 func (v ValRef) Type() ExprType { return ExprValRef }
@@ -120,6 +125,7 @@ func (f FnRef) String() string { return "$" + Path(f).String() }
 type FnCall struct {
 	FnRef FnRef
 	Args  []Expr
+	Fn    Fn // Fn is the function resolved by Compile, it's looked up by FnRef if nil
 }
 
 func (f FnCall) String() string {
@@ -150,6 +156,9 @@ type Fn interface {
 type Evaller interface {
 	SubEvaller(scopedValue Expr) Evaller
 	Eval(expr Expr) (Expr, error)
+	// Context returns the context of evaluation, a long running function
+	// should stop when it's done.
+	Context() context.Context
 }
 
 type TenpenFn struct {
@@ -180,9 +189,9 @@ func (f TenpenFn) Apply(e Evaller, args []Expr) (Expr, error) {
 	for i := len(args); i < len(f.Args); i++ {
 		args = append(args, Null{})
 	}
-	scope := Object{}
+	scope := NewObject(len(f.Args))
 	for i, arg := range f.Args {
-		scope[string(arg)] = args[i]
+		scope.Set(string(arg), args[i])
 	}
 	return e.SubEvaller(scope).Eval(f.Body)
 }
@@ -200,3 +209,58 @@ func (f GoFn) Type() ExprType {
 func (f GoFn) Apply(e Evaller, args []Expr) (Expr, error) {
 	return f(e, args)
 }
+
+// Form is a function whose arguments are passed without evaluation, it
+// decides which arguments to evaluate by e.Eval. It is used by special forms
+// like "and", "or", which should not evaluate all the arguments.
+type Form func(e Evaller, args []Expr) (Expr, error)
+
+func (f Form) String() string {
+	return "<form>"
+}
+
+func (f Form) Type() ExprType {
+	return ExprFn
+}
+
+func (f Form) Apply(e Evaller, args []Expr) (Expr, error) {
+	return f(e, args)
+}
+
+// Equal reports whether a and b are deeply equal values.
+func Equal(a, b Expr) bool {
+	switch a := a.(type) {
+	case Null:
+		return b.Type() == ExprNull
+	case String, Bool:
+		return a == b
+	case Number, Int, Decimal:
+		c, err := compareNumbers(a, b)
+		return err == nil && c == 0
+	case Array:
+		b, ok := b.(Array)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !Equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case Object:
+		b, ok := b.(Object)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		for k, v := range a.All() {
+			bv, ok := b.Get(k)
+			if !ok || !Equal(v, bv) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,55 @@
+package lg
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// FactSource provides facts on demand. It's consulted when a value reference
+// is not found in the environments and rule, so only the referenced paths are
+// fetched.
+type FactSource interface {
+	// Get returns the value at path, ok is false if there is no such value.
+	Get(ctx context.Context, path Path) (v Expr, ok bool, err error)
+}
+
+// FactSourceFunc is a function that implements FactSource.
+type FactSourceFunc func(ctx context.Context, path Path) (Expr, bool, error)
+
+func (f FactSourceFunc) Get(ctx context.Context, path Path) (Expr, bool, error) {
+	return f(ctx, path)
+}
+
+// facts memoizes the facts got from source in an evaluation, it's shared by
+// the evaluator and its sub evaluators.
+type facts struct {
+	source FactSource
+	got    map[string]fact
+}
+
+type fact struct {
+	v  Expr
+	ok bool
+}
+
+func (f *facts) get(ctx context.Context, path Path) (Expr, bool, error) {
+	key := path.String()
+	if got, ok := f.got[key]; ok {
+		return got.v, got.ok, nil
+	}
+	v, ok, err := f.source.Get(ctx, path)
+	if err != nil {
+		var te *tperr.Error
+		if errors.As(err, &te) {
+			return nil, false, err
+		}
+		return nil, false, tperr.FactSourceError(err)
+	}
+	if ok && v == nil {
+		v = Null{}
+	}
+	f.got[key] = fact{v: v, ok: ok}
+	return v, ok, nil
+}
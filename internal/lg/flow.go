@@ -0,0 +1,95 @@
+package lg
+
+// ifForm: ["$if", cond, then, else], else is optional.
+func ifForm(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, arityError("2 or 3", len(args))
+	}
+	ok, err := evalBool(e, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return e.Eval(args[1])
+	}
+	if len(args) == 3 {
+		return e.Eval(args[2])
+	}
+	return Null{}, nil
+}
+
+// cond: ["$cond", cond1, value1, cond2, value2, ..., default], default is
+// optional.
+func cond(e Evaller, args []Expr) (Expr, error) {
+	for i := 0; i+1 < len(args); i += 2 {
+		ok, err := evalBool(e, args[i])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return e.Eval(args[i+1])
+		}
+	}
+	if len(args)%2 == 1 {
+		return e.Eval(args[len(args)-1])
+	}
+	return Null{}, nil
+}
+
+// let: ["$let", {"name": value, ...}, body]. The bindings are evaluated like a
+// rule object, then the body is evaluated with the bindings in scope.
+func let(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 2 {
+		return nil, arityError("2", len(args))
+	}
+	if args[0].Type() != ExprObject {
+		return nil, typeError("object", args[0])
+	}
+	scope := e.SubEvaller(Null{})
+	if _, err := scope.Eval(args[0]); err != nil {
+		return nil, err
+	}
+	return scope.SubEvaller(Null{}).Eval(args[1])
+}
+
+// do: ["$do", expr1, expr2, ...], evaluates the expressions in order, returns
+// the last one.
+func do(e Evaller, args []Expr) (Expr, error) {
+	var result Expr = Null{}
+	for _, arg := range args {
+		v, err := e.Eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		result = v
+	}
+	return result, nil
+}
+
+// apply: ["$apply", fn, [arg1, arg2, ...]]. It's a form, so the errors of fn
+// are attributed to fn by its name, not to apply.
+func apply(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 2 {
+		return nil, arityError("2", len(args))
+	}
+	v, err := e.Eval(args[0])
+	if err != nil {
+		return nil, err
+	}
+	fn, err := as[Fn](v)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = e.Eval(args[1]); err != nil {
+		return nil, err
+	}
+	fnArgs, err := as[Array](v)
+	if err != nil {
+		return nil, err
+	}
+	v, err = fn.Apply(e, fnArgs)
+	if ref, ok := source(args[0]).(FnRef); ok {
+		err = inFn(err, ref)
+	}
+	return v, err
+}
@@ -0,0 +1,67 @@
+package lg
+
+import "github.com/nanozuki/tenpen/tperr"
+
+// Limits caps the resources used by an evaluation, a zero field means no limit.
+type Limits struct {
+	MaxSteps       int // MaxSteps is the number of evaluated expressions
+	MaxDepth       int // MaxDepth is the nesting depth of function calls and scopes
+	MaxValueSize   int // MaxValueSize is the length of an evaluated array or object, or the bytes of a string
+	MaxOutputBytes int // MaxOutputBytes is the size of serialized output
+}
+
+// CheckOutput checks the size of serialized output.
+func (l Limits) CheckOutput(n int) error {
+	if l.MaxOutputBytes > 0 && n > l.MaxOutputBytes {
+		return tperr.LimitExceededError("MaxOutputBytes", l.MaxOutputBytes)
+	}
+	return nil
+}
+
+// meter counts the resources of an evaluation, it's shared by the evaluator
+// and its sub evaluators.
+type meter struct {
+	limits Limits
+	steps  int
+}
+
+func (m *meter) step(depth int) error {
+	m.steps++
+	if m.limits.MaxSteps > 0 && m.steps > m.limits.MaxSteps {
+		return tperr.LimitExceededError("MaxSteps", m.limits.MaxSteps)
+	}
+	if m.limits.MaxDepth > 0 && depth > m.limits.MaxDepth {
+		return tperr.LimitExceededError("MaxDepth", m.limits.MaxDepth)
+	}
+	return nil
+}
+
+func (m *meter) checkSize(v Expr) error {
+	switch v := v.(type) {
+	case Array:
+		return m.allow(len(v))
+	case Object:
+		return m.allow(v.Len())
+	case String:
+		return m.allow(len(v))
+	}
+	return nil
+}
+
+// allow checks the size of a value before it's built, n is the length of an
+// array or object, or the bytes of a string.
+func (m *meter) allow(n int) error {
+	if m.limits.MaxValueSize > 0 && n > m.limits.MaxValueSize {
+		return tperr.LimitExceededError("MaxValueSize", m.limits.MaxValueSize)
+	}
+	return nil
+}
+
+// allowSize checks the size of a value a builtin is going to build, like
+// meter.allow, if e is an Evaluator.
+func allowSize(e Evaller, n int) error {
+	if ev, ok := e.(*Evaluator); ok {
+		return ev.meter.allow(n)
+	}
+	return nil
+}
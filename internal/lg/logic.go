@@ -0,0 +1,122 @@
+package lg
+
+import "strings"
+
+func and(e Evaller, args []Expr) (Expr, error) {
+	for _, arg := range args {
+		b, err := evalBool(e, arg)
+		if err != nil {
+			return nil, err
+		}
+		if !b {
+			return Bool(false), nil
+		}
+	}
+	return Bool(true), nil
+}
+
+func or(e Evaller, args []Expr) (Expr, error) {
+	for _, arg := range args {
+		b, err := evalBool(e, arg)
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			return Bool(true), nil
+		}
+	}
+	return Bool(false), nil
+}
+
+func evalBool(e Evaller, expr Expr) (Bool, error) {
+	v, err := e.Eval(expr)
+	if err != nil {
+		return false, err
+	}
+	b, err := as[Bool](v)
+	if err != nil {
+		return false, err
+	}
+	return b, nil
+}
+
+func not(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 1 {
+		return nil, arityError("1", len(args))
+	}
+	b, err := as[Bool](args[0])
+	if err != nil {
+		return nil, err
+	}
+	return !b, nil
+}
+
+func eq(e Evaller, args []Expr) (Expr, error) {
+	if len(args) < 2 {
+		return nil, arityError("at least 2", len(args))
+	}
+	for _, arg := range args[1:] {
+		if !Equal(args[0], arg) {
+			return Bool(false), nil
+		}
+	}
+	return Bool(true), nil
+}
+
+// ne is the negation of eq, it's true if the arguments are not all equal,
+// like ["$!=", 1, 1, 2].
+func ne(e Evaller, args []Expr) (Expr, error) {
+	equal, err := eq(e, args)
+	if err != nil {
+		return nil, err
+	}
+	return !equal.(Bool), nil
+}
+
+func gt(e Evaller, args []Expr) (Expr, error) {
+	return compareChain(args, func(c int) bool { return c > 0 })
+}
+
+func lt(e Evaller, args []Expr) (Expr, error) {
+	return compareChain(args, func(c int) bool { return c < 0 })
+}
+
+func ge(e Evaller, args []Expr) (Expr, error) {
+	return compareChain(args, func(c int) bool { return c >= 0 })
+}
+
+func le(e Evaller, args []Expr) (Expr, error) {
+	return compareChain(args, func(c int) bool { return c <= 0 })
+}
+
+// compareChain checks every adjacent pair of args, like a < b < c.
+func compareChain(args []Expr, ok func(c int) bool) (Expr, error) {
+	if len(args) < 2 {
+		return nil, arityError("at least 2", len(args))
+	}
+	result := true
+	for i := 1; i < len(args); i++ {
+		c, err := compare(args[i-1], args[i])
+		if err != nil {
+			return nil, err
+		}
+		result = result && ok(c)
+	}
+	return Bool(result), nil
+}
+
+// compare compares two numbers or two strings.
+func compare(a, b Expr) (int, error) {
+	switch a := a.(type) {
+	case Number, Int, Decimal:
+		return compareNumbers(a, b)
+	case String:
+		b, err := as[String](b)
+		if err != nil {
+			return 0, err
+		}
+		return strings.Compare(string(a), string(b)), nil
+	default:
+		return 0, typeError("number or string", a)
+	}
+}
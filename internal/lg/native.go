@@ -0,0 +1,653 @@
+package lg
+
+import (
+	"cmp"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// ExprFromGo converts a Go value into an expression, like encoding/json
+// encodes it: structs are converted by their json tags, and the types
+// implementing json.Marshaler or encoding.TextMarshaler are converted by them.
+// Unlike rule, the strings are never references. A value that refers to
+// itself, like a pointer cycle, is an error.
+func ExprFromGo(v any) (Expr, error) {
+	return exprFromGo(reflect.ValueOf(v), Path{}, map[ptrKey]struct{}{})
+}
+
+// ptrKey is a pointer, map or slice being converted, the length tells the
+// slices of the same array apart.
+type ptrKey struct {
+	t   reflect.Type
+	ptr uintptr
+	len int
+}
+
+var (
+	jsonNumber    = reflect.TypeFor[json.Number]()
+	decimalType   = reflect.TypeFor[Decimal]()
+	jsonMarshaler = reflect.TypeFor[json.Marshaler]()
+	textMarshaler = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// exprFromGo converts rv at loc, seen are the pointers being converted on the
+// way to rv.
+func exprFromGo(rv reflect.Value, loc Path, seen map[ptrKey]struct{}) (Expr, error) {
+	if !rv.IsValid() {
+		return Null{}, nil
+	}
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		if rv.IsNil() {
+			return Null{}, nil
+		}
+	}
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		key := ptrKey{t: rv.Type(), ptr: rv.Pointer()}
+		if rv.Kind() == reflect.Slice {
+			key.len = rv.Len()
+		}
+		if _, ok := seen[key]; ok {
+			return nil, locate(tperr.InvalidArgError().WithDetail("encountered a cycle via %s", rv.Type()), loc, rv.Type().String())
+		}
+		seen[key] = struct{}{}
+		defer delete(seen, key)
+	}
+	if rv.CanInterface() {
+		if expr, ok := rv.Interface().(Expr); ok {
+			return expr, nil
+		}
+	}
+	if rv.Type() == jsonNumber {
+		expr, err := exprFromValue(json.Number(rv.String()), loc, false)
+		if err != nil {
+			return nil, err
+		}
+		return expr, nil
+	}
+	if rv.CanInterface() && rv.Type().Implements(jsonMarshaler) {
+		data, err := rv.Interface().(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return nil, locate(tperr.InvalidJSONError().WithDetail("%s", err), loc, rv.Type().String())
+		}
+		var jv any
+		if err := json.Unmarshal(data, &jv); err != nil {
+			return nil, locate(tperr.InvalidJSONError().WithDetail("%s", err), loc, rv.Type().String())
+		}
+		return exprFromGo(reflect.ValueOf(jv), loc, seen)
+	}
+	if rv.CanInterface() && rv.Type().Implements(textMarshaler) {
+		text, err := rv.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, locate(tperr.InvalidArgError().WithDetail("%s", err), loc, rv.Type().String())
+		}
+		return String(text), nil
+	}
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return exprFromGo(rv.Elem(), loc, seen)
+	case reflect.Bool:
+		return Bool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n := rv.Uint(); n <= math.MaxInt64 {
+			return Int(n), nil
+		}
+		return ParseDecimal(strconv.FormatUint(rv.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		return Number(rv.Float()), nil
+	case reflect.String:
+		return String(rv.String()), nil
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			return String(base64.StdEncoding.EncodeToString(rv.Bytes())), nil
+		}
+		arr := make(Array, 0, rv.Len())
+		for i := range rv.Len() {
+			v, err := exprFromGo(rv.Index(i), append(loc, NumberStep(i)), seen)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case reflect.Map:
+		// the keys are sorted like encoding/json does
+		fields := make(map[string]Expr, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k, err := mapKey(iter.Key())
+			if err != nil {
+				return nil, locate(err, loc, rv.Type().String())
+			}
+			v, err := exprFromGo(iter.Value(), append(loc, StringStep(k)), seen)
+			if err != nil {
+				return nil, err
+			}
+			fields[k] = v
+		}
+		return ObjectOf(fields), nil
+	case reflect.Struct:
+		fields := structFields(rv.Type())
+		obj := NewObject(len(fields))
+		for _, f := range fields {
+			fv, ok := fieldByIndex(rv, f.index)
+			if !ok || f.omitEmpty && isEmpty(fv) {
+				continue
+			}
+			v, err := exprFromGo(fv, append(loc, StringStep(f.name)), seen)
+			if err != nil {
+				return nil, err
+			}
+			if f.quoted {
+				if v, err = quote(v); err != nil {
+					return nil, locate(err, append(loc, StringStep(f.name)), fv.Type().String())
+				}
+			}
+			obj.Set(f.name, v)
+		}
+		return obj, nil
+	default:
+		return nil, locate(tperr.InvalidTypeError().WithDetail("can't convert %s", rv.Type()), loc, rv.Type().String())
+	}
+}
+
+// isEmpty reports whether v is empty for omitempty, like encoding/json: false,
+// 0, a nil pointer or interface, and an empty array, slice, map or string. A
+// struct is never empty.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// quote converts a value of a field with the string option into a string of
+// its json, like 12 into "12". null is kept.
+func quote(v Expr) (Expr, error) {
+	if _, ok := v.(Null); ok {
+		return v, nil
+	}
+	data, err := ExprToBytes(v)
+	if err != nil {
+		return nil, err
+	}
+	return String(data), nil
+}
+
+// unquote converts the string of a field with the string option back into the
+// value of its json.
+func unquote(v Expr, loc Path) (Expr, error) {
+	if _, ok := v.(Null); ok {
+		return v, nil
+	}
+	s, err := as[String](v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(s)))
+	dec.UseNumber()
+	var jv any
+	if err := dec.Decode(&jv); err != nil || dec.More() {
+		return nil, tperr.InvalidArgError().WithDetail("invalid quoted value %q", s)
+	}
+	return exprFromGo(reflect.ValueOf(jv), loc, map[ptrKey]struct{}{})
+}
+
+func mapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if k.Type().Implements(textMarshaler) {
+		text, err := k.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return "", tperr.InvalidArgError().WithDetail("%s", err)
+		}
+		return string(text), nil
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", tperr.InvalidTypeError().WithDetail("can't convert map key of %s", k.Type())
+}
+
+// field is an exported field of struct, named by its json tag.
+type field struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	tagged    bool // tagged means the name is from json tag
+	omitEmpty bool
+	quoted    bool // quoted means the value is in a string, by the string option
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// structFields returns the fields of struct type t by the rules of
+// encoding/json. The fields of embedded structs without a name in tag are
+// promoted. Of the fields of the same name, the shallowest one is used, a
+// tagged one is preferred if they are at the same depth, and they are all
+// dropped if it's still ambiguous.
+func structFields(t reflect.Type) []field {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]field)
+	}
+	var fields []field
+	current := []field{}
+	next := []field{{typ: t}}
+	// count and nextCount are the numbers of the times a struct type is
+	// embedded at the current and next depth
+	var count, nextCount map[reflect.Type]int
+	visited := map[reflect.Type]bool{}
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+			for i := range f.typ.NumField() {
+				sf := f.typ.Field(i)
+				if sf.Anonymous {
+					et := sf.Type
+					if et.Kind() == reflect.Pointer {
+						et = et.Elem()
+					}
+					if !sf.IsExported() && et.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := append(append([]int(nil), f.index...), i)
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					quoted := false
+					if hasOption(opts, "string") {
+						switch ft.Kind() {
+						case reflect.Bool,
+							reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+							reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+							reflect.Float32, reflect.Float64,
+							reflect.String:
+							quoted = true
+						}
+					}
+					tagged := name != ""
+					if name == "" {
+						name = sf.Name
+					}
+					fields = append(fields, field{
+						name:      name,
+						index:     index,
+						typ:       ft,
+						tagged:    tagged,
+						omitEmpty: hasOption(opts, "omitempty"),
+						quoted:    quoted,
+					})
+					if count[f.typ] > 1 {
+						// the struct is embedded more than once at this depth,
+						// so its fields are duplicated to be dropped below
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, field{index: index, typ: ft})
+				}
+			}
+		}
+	}
+	slices.SortStableFunc(fields, func(a, b field) int {
+		if c := strings.Compare(a.name, b.name); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(len(a.index), len(b.index)); c != 0 {
+			return c
+		}
+		if a.tagged != b.tagged {
+			if a.tagged {
+				return -1
+			}
+			return 1
+		}
+		return slices.Compare(a.index, b.index)
+	})
+	out := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		if dominant, ok := dominantField(fields[i:j]); ok {
+			out = append(out, dominant)
+		}
+		i = j
+	}
+	fields = out
+	slices.SortFunc(fields, func(a, b field) int {
+		return slices.Compare(a.index, b.index)
+	})
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// dominantField returns the field used of the fields of the same name, which
+// are sorted by depth and tagged first. ok is false if they are ambiguous.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+func hasOption(opts, name string) bool {
+	return strings.Contains(","+opts+",", ","+name+",")
+}
+
+// fieldByIndex returns the field of rv by index, ok is false if it's in a nil
+// embedded pointer.
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+// ExprInto decodes expr into the value that out points to, like encoding/json
+// decodes json. A json.Unmarshaler or encoding.TextUnmarshaler decodes itself.
+func ExprInto(expr Expr, out any) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return tperr.InvalidArgError().WithDetail("expects a non-nil pointer, got %T", out)
+	}
+	return exprInto(expr, rv.Elem(), Path{})
+}
+
+func exprInto(expr Expr, rv reflect.Value, loc Path) error {
+	if err := decode(expr, rv, loc); err != nil {
+		return locate(err, loc, expr.String())
+	}
+	return nil
+}
+
+func decode(expr Expr, rv reflect.Value, loc Path) error {
+	if rv.Type() == decimalType {
+		d, err := toDecimal(expr)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(d))
+		return nil
+	}
+	if rv.Kind() != reflect.Pointer && rv.CanAddr() {
+		if u, ok := rv.Addr().Interface().(json.Unmarshaler); ok {
+			data, err := ExprToBytes(expr)
+			if err != nil {
+				return err
+			}
+			if err := u.UnmarshalJSON(data); err != nil {
+				return tperr.InvalidArgError().WithDetail("%s", err)
+			}
+			return nil
+		}
+		if u, ok := rv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			if s, ok := expr.(String); ok {
+				if err := u.UnmarshalText([]byte(s)); err != nil {
+					return tperr.InvalidArgError().WithDetail("%s", err)
+				}
+				return nil
+			}
+		}
+	}
+	if _, ok := expr.(Null); ok {
+		switch rv.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+			rv.SetZero()
+		}
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return exprInto(expr, rv.Elem(), loc)
+	case reflect.Interface:
+		if rv.NumMethod() > 0 {
+			return tperr.InvalidTypeError().WithDetail("can't decode into %s", rv.Type())
+		}
+		rv.Set(reflect.ValueOf(ExprToValue(expr)))
+	case reflect.Bool:
+		b, err := as[Bool](expr)
+		if err != nil {
+			return err
+		}
+		rv.SetBool(bool(b))
+	case reflect.String:
+		s, err := as[String](expr)
+		if err != nil {
+			return err
+		}
+		rv.SetString(string(s))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok, err := toInt(expr)
+		if err != nil {
+			return err
+		}
+		if !ok || rv.OverflowInt(n) {
+			return tperr.InvalidTypeError().WithDetail("%s overflows %s", numberText(expr), rv.Type())
+		}
+		rv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		var ok bool
+		if d, isDec := expr.(Decimal); isDec {
+			n, ok = d.uint64() // may be out of the range of int64
+		} else {
+			i, isInt, err := toInt(expr)
+			if err != nil {
+				return err
+			}
+			n, ok = uint64(i), isInt && i >= 0
+		}
+		if !ok || rv.OverflowUint(n) {
+			return tperr.InvalidTypeError().WithDetail("%s overflows %s", numberText(expr), rv.Type())
+		}
+		rv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := toFloat(expr)
+		if err != nil {
+			return err
+		}
+		if rv.OverflowFloat(n) {
+			return tperr.InvalidTypeError().WithDetail("%v overflows %s", n, rv.Type())
+		}
+		rv.SetFloat(n)
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			if s, ok := expr.(String); ok {
+				b, err := base64.StdEncoding.DecodeString(string(s))
+				if err != nil {
+					return tperr.InvalidArgError().WithDetail("%s", err)
+				}
+				rv.SetBytes(b)
+				return nil
+			}
+		}
+		arr, err := as[Array](expr)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.MakeSlice(rv.Type(), len(arr), len(arr)))
+		for i, v := range arr {
+			if err := exprInto(v, rv.Index(i), append(loc, NumberStep(i))); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		arr, err := as[Array](expr)
+		if err != nil {
+			return err
+		}
+		for i := range rv.Len() {
+			if i >= len(arr) {
+				rv.Index(i).SetZero()
+				continue
+			}
+			if err := exprInto(arr[i], rv.Index(i), append(loc, NumberStep(i))); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		obj, err := as[Object](expr)
+		if err != nil {
+			return err
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMapWithSize(rv.Type(), obj.Len()))
+		}
+		for k, v := range obj.shown() {
+			key, err := decodeKey(k, rv.Type().Key())
+			if err != nil {
+				return err
+			}
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			if err := exprInto(v, elem, append(loc, StringStep(k))); err != nil {
+				return err
+			}
+			rv.SetMapIndex(key, elem)
+		}
+	case reflect.Struct:
+		obj, err := as[Object](expr)
+		if err != nil {
+			return err
+		}
+		fields := structFields(rv.Type())
+		for k, v := range obj.shown() {
+			f, ok := findField(fields, k)
+			if !ok {
+				continue
+			}
+			fv, err := allocField(rv, f.index)
+			if err != nil {
+				return err
+			}
+			if f.quoted {
+				uv, err := unquote(v, append(loc, StringStep(k)))
+				if err != nil {
+					return locate(err, append(loc, StringStep(k)), v.String())
+				}
+				v = uv
+			}
+			if err := exprInto(v, fv, append(loc, StringStep(k))); err != nil {
+				return err
+			}
+		}
+	default:
+		return tperr.InvalidTypeError().WithDetail("can't decode into %s", rv.Type())
+	}
+	return nil
+}
+
+func decodeKey(k string, t reflect.Type) (reflect.Value, error) {
+	key := reflect.New(t)
+	if u, ok := key.Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(k)); err != nil {
+			return reflect.Value{}, tperr.InvalidArgError().WithDetail("%s", err)
+		}
+		return key.Elem(), nil
+	}
+	key = key.Elem()
+	switch t.Kind() {
+	case reflect.String:
+		key.SetString(k)
+		return key, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(k, 10, 64)
+		if err != nil || key.OverflowInt(n) {
+			return reflect.Value{}, tperr.InvalidTypeError().WithDetail("can't decode key %q into %s", k, t)
+		}
+		key.SetInt(n)
+		return key, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(k, 10, 64)
+		if err != nil || key.OverflowUint(n) {
+			return reflect.Value{}, tperr.InvalidTypeError().WithDetail("can't decode key %q into %s", k, t)
+		}
+		key.SetUint(n)
+		return key, nil
+	}
+	return reflect.Value{}, tperr.InvalidTypeError().WithDetail("can't decode key into %s", t)
+}
+
+// findField finds the field named k, an exact match is preferred over a case
+// insensitive one.
+func findField(fields []field, k string) (field, bool) {
+	for _, f := range fields {
+		if f.name == k {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, k) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// allocField returns the field of rv by index, the nil embedded pointers on
+// the way are allocated.
+func allocField(rv reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				if !rv.CanSet() {
+					return reflect.Value{}, tperr.InvalidTypeError().WithDetail("can't set embedded pointer to unexported %s", rv.Type().Elem())
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, nil
+}
@@ -0,0 +1,162 @@
+package lg
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// Int is an integer number. In float mode the integers in json are parsed as
+// Int, so an ID like 9007199254740993 keeps all its digits.
+type Int int64
+
+func (n Int) String() string {
+	return fmt.Sprintf("int<%d>", int64(n))
+}
+
+func (n Int) Type() ExprType {
+	return ExprNumber
+}
+
+// parseNumber parses a json number in float mode. An integer is parsed as Int,
+// or as Decimal if it's out of the range of int64, so it's written back with
+// the same digits. The others are parsed as Number, which is written in the
+// shortest form of the float, like 1.5 for 1.50.
+func parseNumber(s string) (Expr, error) {
+	if !strings.ContainsAny(s, ".eE") {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return Int(n), nil
+		}
+		return ParseDecimal(s)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, tperr.InvalidJSONError().WithDetail("%s", err)
+	}
+	return Number(f), nil
+}
+
+func addInt(a, b int64) (int64, bool) {
+	r := a + b
+	return r, (r > a) == (b > 0)
+}
+
+func subInt(a, b int64) (int64, bool) {
+	r := a - b
+	return r, (r < a) == (b > 0)
+}
+
+func mulInt(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	r := a * b
+	return r, r/b == a && !(a == math.MinInt64 && b == -1)
+}
+
+func quoInt(a, b int64) (int64, bool) {
+	if b == 0 || a%b != 0 || (a == math.MinInt64 && b == -1) {
+		return 0, false
+	}
+	return a / b, true
+}
+
+// toDecimal converts a number to decimal.
+func toDecimal(n Expr) (Decimal, error) {
+	switch n := n.(type) {
+	case Decimal:
+		return n, nil
+	case Int:
+		return NewDecimal(int64(n), 0), nil
+	case Number:
+		return DecimalFromFloat(float64(n))
+	default:
+		return Decimal{}, typeError("number", n)
+	}
+}
+
+// toFloat converts a number to float.
+func toFloat(n Expr) (float64, error) {
+	switch n := n.(type) {
+	case Number:
+		return float64(n), nil
+	case Int:
+		return float64(n), nil
+	case Decimal:
+		return n.Float64(), nil
+	default:
+		return 0, typeError("number", n)
+	}
+}
+
+// toInt converts a number to int64, ok is false if it isn't an integer in the
+// range of int64.
+func toInt(n Expr) (i int64, ok bool, err error) {
+	switch n := n.(type) {
+	case Int:
+		return int64(n), true, nil
+	case Number:
+		f := float64(n)
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false, nil
+		}
+		return int64(f), true, nil
+	case Decimal:
+		i, ok := n.Int64()
+		return i, ok, nil
+	default:
+		return 0, false, typeError("number", n)
+	}
+}
+
+// numberText formats a number for error messages.
+func numberText(n Expr) string {
+	switch n := n.(type) {
+	case Int:
+		return strconv.FormatInt(int64(n), 10)
+	case Number:
+		return fmt.Sprintf("%v", float64(n))
+	case Decimal:
+		return n.Text()
+	default:
+		return n.String()
+	}
+}
+
+// compareNumbers compares two numbers. Two floats or two integers are compared
+// directly, the others are compared as decimals.
+func compareNumbers(a, b Expr) (int, error) {
+	switch x := a.(type) {
+	case Number:
+		if y, ok := b.(Number); ok {
+			return cmpOrdered(x, y), nil
+		}
+	case Int:
+		if y, ok := b.(Int); ok {
+			return cmpOrdered(x, y), nil
+		}
+	}
+	da, err := toDecimal(a)
+	if err != nil {
+		return 0, err
+	}
+	db, err := toDecimal(b)
+	if err != nil {
+		return 0, err
+	}
+	return da.Cmp(db), nil
+}
+
+func cmpOrdered[T Number | Int](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,299 @@
+package lg
+
+import (
+	"sort"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// The object functions never modify their arguments, a new object is returned
+// instead.
+
+func objectArg(arg Expr) (Object, error) {
+	return as[Object](arg)
+}
+
+func sortedKeys(obj Object) []string {
+	keys := obj.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
+func pathArg(arg Expr) (Path, error) {
+	switch arg := arg.(type) {
+	case String:
+		return ParsePath(string(arg))
+	case Number, Int, Decimal:
+		n, err := intArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		return Path{NumberStep(n)}, nil
+	default:
+		return nil, typeError("path string or index", arg)
+	}
+}
+
+// keys: ["$keys", obj], the keys are sorted.
+func keys(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 1 {
+		return nil, arityError("1", len(args))
+	}
+	obj, err := objectArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	arr := make(Array, 0, obj.Len())
+	for _, k := range sortedKeys(obj) {
+		arr = append(arr, String(k))
+	}
+	return arr, nil
+}
+
+// values: ["$values", obj], the values are in the order of sorted keys.
+func values(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 1 {
+		return nil, arityError("1", len(args))
+	}
+	obj, err := objectArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	arr := make(Array, 0, obj.Len())
+	for _, k := range sortedKeys(obj) {
+		v, _ := obj.Get(k)
+		arr = append(arr, v)
+	}
+	return arr, nil
+}
+
+// get: ["$get", target, path, default], path is a path string like "a.0.b" or
+// an array index. It returns default, or null if omitted, when path is not
+// found.
+func get(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, arityError("2 or 3", len(args))
+	}
+	path, err := pathArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	v, err := path.GetFrom(args[0])
+	if err != nil {
+		if len(args) == 3 {
+			return args[2], nil
+		}
+		return Null{}, nil
+	}
+	return v, nil
+}
+
+// merge: ["$merge", obj1, obj2, ...], the later objects take precedence. The
+// keys are in the order they first appear.
+func merge(e Evaller, args []Expr) (Expr, error) {
+	result := NewObject(0)
+	for _, arg := range args {
+		obj, err := objectArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range obj.All() {
+			result.Set(k, v)
+		}
+	}
+	return result, nil
+}
+
+// deepMerge: ["$deep-merge", obj1, obj2, ...], like merge, but the nested
+// objects are merged recursively.
+func deepMerge(e Evaller, args []Expr) (Expr, error) {
+	var result Expr = NewObject(0)
+	for _, arg := range args {
+		if _, err := objectArg(arg); err != nil {
+			return nil, err
+		}
+		result = mergeValue(result, arg)
+	}
+	return result, nil
+}
+
+func mergeValue(dst, src Expr) Expr {
+	d, ok1 := dst.(Object)
+	s, ok2 := src.(Object)
+	if !ok1 || !ok2 {
+		return src
+	}
+	result := d.Clone()
+	for k, v := range s.All() {
+		if dv, ok := result.Get(k); ok {
+			result.Set(k, mergeValue(dv, v))
+		} else {
+			result.Set(k, v)
+		}
+	}
+	return result
+}
+
+func keysArg(arg Expr) (map[string]struct{}, error) {
+	arr, err := as[Array](arg)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]struct{}, len(arr))
+	for _, v := range arr {
+		s, err := as[String](v)
+		if err != nil {
+			return nil, err
+		}
+		keys[string(s)] = struct{}{}
+	}
+	return keys, nil
+}
+
+// pick: ["$pick", obj, [key1, key2, ...]]
+func pick(e Evaller, args []Expr) (Expr, error) {
+	return filterKeys(args, true)
+}
+
+// omit: ["$omit", obj, [key1, key2, ...]]
+func omit(e Evaller, args []Expr) (Expr, error) {
+	return filterKeys(args, false)
+}
+
+func filterKeys(args []Expr, keep bool) (Expr, error) {
+	if len(args) != 2 {
+		return nil, arityError("2", len(args))
+	}
+	obj, err := objectArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	keys, err := keysArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	result := NewObject(0)
+	for k, v := range obj.All() {
+		if _, ok := keys[k]; ok == keep {
+			result.Set(k, v)
+		}
+	}
+	return result, nil
+}
+
+// entries: ["$entries", obj], returns [[key, value], ...] in the order of
+// sorted keys.
+func entries(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 1 {
+		return nil, arityError("1", len(args))
+	}
+	obj, err := objectArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	arr := make(Array, 0, obj.Len())
+	for _, k := range sortedKeys(obj) {
+		v, _ := obj.Get(k)
+		arr = append(arr, Array{String(k), v})
+	}
+	return arr, nil
+}
+
+// fromEntries: ["$from-entries", [[key, value], ...]]
+func fromEntries(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 1 {
+		return nil, arityError("1", len(args))
+	}
+	arr, err := as[Array](args[0])
+	if err != nil {
+		return nil, err
+	}
+	result := NewObject(len(arr))
+	for _, v := range arr {
+		entry, ok := v.(Array)
+		if !ok || len(entry) != 2 {
+			return nil, tperr.InvalidArgError().WithDetail("expects [key, value] entry, got %s", v.Type())
+		}
+		k, err := as[String](entry[0])
+		if err != nil {
+			return nil, err
+		}
+		result.Set(string(k), entry[1])
+	}
+	return result, nil
+}
+
+// set: ["$set", target, path, value], returns a copy of target with value set
+// at path, the missing containers on the path are created. An array is padded
+// with nulls up to the index, by less than maxSetGrowth elements.
+func set(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 3 {
+		return nil, arityError("3", len(args))
+	}
+	path, err := pathArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return setIn(e, args[0], path, args[2])
+}
+
+// maxSetGrowth is the max number of elements set adds to an array, which
+// bounds it even if there are no limits.
+const maxSetGrowth = 1 << 20
+
+// setIn is the immutable version of Path.SetTo, only the containers on the
+// path are copied. An array is not grown by more than maxSetGrowth, or beyond
+// the size limit of e.
+func setIn(e Evaller, target Expr, path Path, value Expr) (Expr, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	switch step := path[0].(type) {
+	case StringStep:
+		var obj Object
+		switch t := target.(type) {
+		case Object:
+			obj = t.Clone()
+		case Null, nil:
+			obj = NewObject(1)
+		default:
+			return nil, typeError("object", target)
+		}
+		old, _ := obj.Get(string(step))
+		v, err := setIn(e, old, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		obj.Set(string(step), v)
+		return obj, nil
+	case NumberStep:
+		var arr Array
+		switch t := target.(type) {
+		case Array:
+			arr = append(arr, t...)
+		case Null, nil:
+		default:
+			return nil, typeError("array", target)
+		}
+		if step < 0 {
+			return nil, tperr.InvalidArgError().WithDetail("negative index %d", step)
+		}
+		if int(step)-len(arr) >= maxSetGrowth {
+			return nil, tperr.InvalidArgError().WithDetail("index %d is too far beyond the %d elements", step, len(arr))
+		}
+		if err := allowSize(e, int(step)+1); err != nil {
+			return nil, err
+		}
+		for i := len(arr); i <= int(step); i++ {
+			arr = append(arr, Null{})
+		}
+		v, err := setIn(e, arr[step], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		arr[step] = v
+		return arr, nil
+	default:
+		panic("unreachable")
+	}
+}
@@ -0,0 +1,208 @@
+package lg
+
+import (
+	"iter"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Object is a json object, it keeps its keys in the order they are set. The
+// copies of an Object share the fields once it has any, and the zero Object is
+// empty and ready to use; ObjectOf creates one from a map.
+type Object struct {
+	m *fields
+}
+
+// fields are the fields of an object. A reserved key is in keys, but not set
+// in vals yet, so it keeps its place whenever it's set.
+type fields struct {
+	keys   []string
+	vals   map[string]Expr     // vals of reserved keys are nil
+	n      int                 // n is the number of keys that are set
+	hidden map[string]struct{} // hidden keys are not in the output
+}
+
+// NewObject returns an empty object with space for size keys.
+func NewObject(size int) Object {
+	return Object{m: &fields{
+		keys: make([]string, 0, size),
+		vals: make(map[string]Expr, size),
+	}}
+}
+
+// ObjectOf returns an object of the fields in m, the keys are sorted.
+func ObjectOf(m map[string]Expr) Object {
+	o := NewObject(len(m))
+	for _, k := range sortedMapKeys(m) {
+		o.Set(k, m[k])
+	}
+	return o
+}
+
+func sortedMapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resultObject returns an empty object for the result of rule. Its keys will
+// be in the order of rule, whatever the order they are set in, and the private
+// keys are hidden.
+func resultObject(rule Object) Object {
+	o := NewObject(rule.Len())
+	for k := range rule.All() {
+		o.m.keys = append(o.m.keys, k)
+		o.m.vals[k] = nil
+		if isPrivate(k) {
+			o.hide(k)
+		}
+	}
+	return o
+}
+
+// isPrivate reports whether key is a private key of rule, which can be
+// referenced but is not in the output.
+func isPrivate(key string) bool {
+	return strings.HasPrefix(key, "_")
+}
+
+// hide hides key from the output.
+func (o Object) hide(key string) {
+	if o.m.hidden == nil {
+		o.m.hidden = map[string]struct{}{}
+	}
+	o.m.hidden[key] = struct{}{}
+}
+
+// Hidden reports whether key is hidden from the output, like a private key or
+// a function of rule.
+func (o Object) Hidden(key string) bool {
+	if o.m == nil {
+		return false
+	}
+	_, ok := o.m.hidden[key]
+	return ok
+}
+
+// shown returns an iterator over the keys and values in the output.
+func (o Object) shown() iter.Seq2[string, Expr] {
+	return func(yield func(string, Expr) bool) {
+		for k, v := range o.All() {
+			if !o.Hidden(k) && !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+func (o Object) String() string {
+	b := strings.Builder{}
+	b.WriteByte('{')
+	i := 0
+	for k, v := range o.All() {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('"')
+		b.WriteString(k)
+		b.WriteByte('"')
+		b.WriteByte(':')
+		b.WriteString(v.String())
+		i++
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func (o Object) Type() ExprType { return ExprObject }
+
+// Len returns the number of keys.
+func (o Object) Len() int {
+	if o.m == nil {
+		return 0
+	}
+	return o.m.n
+}
+
+// Get returns the value of key, ok is false if key is not set.
+func (o Object) Get(key string) (v Expr, ok bool) {
+	if o.m == nil {
+		return nil, false
+	}
+	v = o.m.vals[key]
+	return v, v != nil
+}
+
+// Set sets the value of key. A new key is placed after the others, and an
+// existing key keeps its place. The fields of a zero Object are allocated by
+// the first Set.
+func (o *Object) Set(key string, v Expr) {
+	if o.m == nil {
+		*o = NewObject(1)
+	}
+	old, ok := o.m.vals[key]
+	if !ok {
+		o.m.keys = append(o.m.keys, key)
+	}
+	if old == nil {
+		o.m.n++
+	}
+	o.m.vals[key] = v
+}
+
+// Delete removes key from the object.
+func (o Object) Delete(key string) {
+	if o.m == nil {
+		return
+	}
+	old, ok := o.m.vals[key]
+	if !ok {
+		return
+	}
+	if old != nil {
+		o.m.n--
+	}
+	delete(o.m.vals, key)
+	delete(o.m.hidden, key)
+	o.m.keys = slices.DeleteFunc(o.m.keys, func(k string) bool { return k == key })
+}
+
+// Keys returns the keys in order.
+func (o Object) Keys() []string {
+	keys := make([]string, 0, o.Len())
+	for k := range o.All() {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// All returns an iterator over the keys and values in order.
+func (o Object) All() iter.Seq2[string, Expr] {
+	return func(yield func(string, Expr) bool) {
+		if o.m == nil {
+			return
+		}
+		for _, k := range o.m.keys {
+			if v := o.m.vals[k]; v != nil && !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Clone returns a shallow copy of the object, with the same order of keys and
+// hidden keys.
+func (o Object) Clone() Object {
+	c := NewObject(o.Len())
+	for k, v := range o.All() {
+		c.Set(k, v)
+		if o.Hidden(k) {
+			c.hide(k)
+		}
+	}
+	return c
+}
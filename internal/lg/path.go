@@ -30,17 +30,17 @@ func (n NumberStep) String() string     { return strconv.Itoa(int(n)) }
 
 type Path []Step // Path is a list of steps, for example: a.b.c.0.d
 
-func ParsePath(s string) (Path, error) {
-	stepStrs := strings.Split(s, ".")
+func ParsePath(path string) (Path, error) {
+	stepStrs := strings.Split(path, ".")
 	steps := make([]Step, 0, len(stepStrs))
 	for _, s := range stepStrs {
 		switch {
 		case s == "":
-			return nil, tperr.InvalidRefError()
+			return nil, tperr.InvalidRefError().WithDetail("empty step in %q", path)
 		case s[0] >= '0' && s[0] <= '9':
 			n, err := strconv.Atoi(s)
 			if err != nil {
-				return nil, tperr.InvalidRefError()
+				return nil, tperr.InvalidRefError().WithDetail("invalid index %q in %q", s, path)
 			}
 			steps = append(steps, NumberStep(n))
 		default:
@@ -62,72 +62,77 @@ func (r Path) String() string {
 }
 
 func (r Path) GetFrom(target Expr) (Expr, error) {
-	switch {
-	case target.Type() == ExprObject && len(r) > 0 && r[0].StepType() == StepTypeString:
-		obj := target.(Object)
-		key := string(r[0].(StringStep))
-		if _, ok := obj[key]; !ok {
-			return Null{}, nil
-		}
-		if len(r) > 1 {
-			return r[1:].GetFrom(obj[key])
-		}
-		return obj[key], nil
-	case target.Type() == ExprArray && len(r) > 0 && r[0].StepType() == StepTypeNumber:
-		arr := target.(Array)
-		idx := int(r[0].(NumberStep))
-		if idx < 0 || idx >= len(arr) {
-			return Null{}, nil
-		}
-		if len(r) > 1 {
-			return r[1:].GetFrom(arr[idx])
-		}
-		return arr[idx], nil
-	default:
+	v, ok := r.find(target)
+	if !ok {
 		return nil, tperr.NoRefError()
 	}
+	return v, nil
+}
+
+// find is like GetFrom, but reports a missing value by ok, so looking up a
+// value through the layers of scopes allocates no errors.
+func (r Path) find(target Expr) (v Expr, ok bool) {
+	for _, step := range r {
+		switch {
+		case target.Type() == ExprObject && step.StepType() == StepTypeString:
+			if target, ok = target.(Object).Get(string(step.(StringStep))); !ok {
+				return nil, false
+			}
+		case target.Type() == ExprArray && step.StepType() == StepTypeNumber:
+			arr, idx := target.(Array), int(step.(NumberStep))
+			if idx < 0 || idx >= len(arr) {
+				return nil, false
+			}
+			target = arr[idx]
+		default:
+			return nil, false
+		}
+	}
+	return target, true
 }
 
-func (r Path) SetTo(target Expr, value Expr) error {
+// SetTo sets value at the path of target, missing containers are created on
+// the way. It returns the updated target, which is a new one if target is null
+// or an array that has to grow.
+func (r Path) SetTo(target Expr, value Expr) (Expr, error) {
+	if len(r) == 0 {
+		return value, nil
+	}
+	if target == nil || target.Type() == ExprNull {
+		if r[0].StepType() == StepTypeString {
+			target = NewObject(1)
+		} else {
+			target = Array{}
+		}
+	}
 	switch {
-	case target.Type() == ExprObject && len(r) > 0 && r[0].StepType() == StepTypeString:
+	case target.Type() == ExprObject && r[0].StepType() == StepTypeString:
 		obj := target.(Object)
 		key := string(r[0].(StringStep))
-		if len(r) > 1 {
-			if v, ok := obj[key]; !ok || v.Type() == ExprNull {
-				if r[1].StepType() == StepTypeString {
-					obj[key] = Object{}
-				} else {
-					obj[key] = Array{}
-				}
-			}
-			return r[1:].SetTo(obj[key], value)
+		old, _ := obj.Get(key)
+		v, err := r[1:].SetTo(old, value)
+		if err != nil {
+			return nil, err
 		}
-		obj[key] = value
-		return nil
-	case target.Type() == ExprArray && len(r) > 0 && r[0].StepType() == StepTypeNumber:
+		obj.Set(key, v)
+		return obj, nil
+	case target.Type() == ExprArray && r[0].StepType() == StepTypeNumber:
 		arr := target.(Array)
 		idx := int(r[0].(NumberStep))
 		if idx < 0 {
-			return tperr.NoRefError()
+			return nil, tperr.NoRefError()
 		}
 		for i := len(arr); i <= idx; i++ {
 			arr = append(arr, Null{})
 		}
-		if len(r) > 1 {
-			if arr[idx].Type() == ExprNull {
-				if r[1].StepType() == StepTypeString {
-					arr[idx] = Object{}
-				} else {
-					arr[idx] = Array{}
-				}
-			}
-			return r[1:].SetTo(arr[idx], value)
+		v, err := r[1:].SetTo(arr[idx], value)
+		if err != nil {
+			return nil, err
 		}
-		arr[idx] = value
-		return nil
+		arr[idx] = v
+		return arr, nil
 	default:
-		return tperr.NoRefError()
+		return nil, tperr.NoRefError()
 	}
 }
 
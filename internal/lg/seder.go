@@ -1,21 +1,91 @@
 package lg
 
 import (
+	"bytes"
 	"encoding/json"
+	"slices"
 	"strings"
 
 	"github.com/nanozuki/tenpen/tperr"
 )
 
 func ExprFromBytes(data []byte) (Expr, error) {
+	return parseBytes(data, false)
+}
+
+// DecimalFromBytes is like ExprFromBytes, but the numbers are parsed as
+// decimals.
+func DecimalFromBytes(data []byte) (Expr, error) {
+	return parseBytes(data, true)
+}
+
+func parseBytes(data []byte, decimal bool) (Expr, error) {
 	var jv any
-	if err := json.Unmarshal(data, &jv); err != nil {
+	if !json.Valid(data) {
+		err := json.Unmarshal(data, &jv) // for the error
+		return nil, tperr.InvalidJSONError().WithDetail("%s", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // keep the numbers in text
+	jv, err := decodeValue(dec)
+	if err != nil {
+		return nil, tperr.InvalidJSONError().WithDetail("%s", err)
+	}
+	return exprFromValue(jv, Path{}, decimal)
+}
+
+// jsonObject is a json object with its keys in order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	key string
+	val any
+}
+
+// decodeValue decodes a json value like encoding/json decodes into an any,
+// but an object is decoded as jsonObject to keep the order of keys.
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
 		return nil, err
 	}
-	return ExprFromValue(jv)
+	switch tok {
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := dec.Token() // ]
+		return arr, err
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonField{key: key.(string), val: v})
+		}
+		_, err := dec.Token() // }
+		return obj, err
+	default:
+		return tok, nil
+	}
 }
 
 func ExprFromValue(jv any) (Expr, error) {
+	return exprFromValue(jv, Path{}, false)
+}
+
+func exprFromValue(jv any, loc Path, decimal bool) (Expr, error) {
 	switch jv := jv.(type) {
 	case nil:
 		return Null{}, nil
@@ -23,57 +93,91 @@ func ExprFromValue(jv any) (Expr, error) {
 		if strings.HasPrefix(jv, "#") && !strings.HasPrefix(jv, "##") {
 			path, err := ParsePath(jv[1:])
 			if err != nil {
-				return nil, err
+				return nil, locate(err, loc, jv)
 			}
 			return ValRef(path), nil
 		}
 		if strings.HasPrefix(jv, "$") && !strings.HasPrefix(jv, "$$") {
 			path, err := ParsePath(jv[1:])
 			if err != nil {
-				return nil, err
+				return nil, locate(err, loc, jv)
 			}
 			return FnRef(path), nil
 		}
 		return String(jv), nil
 	case float64:
 		return Number(jv), nil
+	case int64:
+		return Int(jv), nil
+	case json.Number:
+		if decimal {
+			d, err := ParseDecimal(string(jv))
+			if err != nil {
+				return nil, locate(err, loc, string(jv))
+			}
+			return d, nil
+		}
+		n, err := parseNumber(string(jv))
+		if err != nil {
+			return nil, locate(err, loc, string(jv))
+		}
+		return n, nil
 	case bool:
 		return Bool(jv), nil
 	case []any:
 		arr := make(Array, 0, len(jv))
-		for _, v := range jv {
-			expr, err := ExprFromValue(v)
+		for i, v := range jv {
+			expr, err := exprFromValue(v, append(loc, NumberStep(i)), decimal)
 			if err != nil {
 				return nil, err
 			}
 			arr = append(arr, expr)
 		}
 		if len(arr) > 0 && arr[0].Type() == ExprFnRef {
+			var expr Expr
+			var err error
 			if arr[0].(FnRef)[0] == StringStep("def") {
-				return parseTenpenFn(arr)
+				expr, err = parseTenpenFn(arr)
+			} else {
+				expr, err = parseFnCall(arr)
+			}
+			if err != nil {
+				return nil, locate(err, loc, arr.String())
 			}
-			return parseFnCall(arr)
+			return expr, nil
 		}
 		return arr, nil
+	case jsonObject:
+		obj := NewObject(len(jv))
+		for _, f := range jv {
+			expr, err := exprFromValue(f.val, append(loc, StringStep(f.key)), decimal)
+			if err != nil {
+				return nil, err
+			}
+			obj.Set(f.key, expr) // a duplicate key keeps the first place
+		}
+		return obj, nil
 	case map[string]any:
-		obj := make(Object, len(jv))
-		for k, v := range jv {
-			expr, err := ExprFromValue(v)
+		// a map has no order, the keys are sorted
+		obj := NewObject(len(jv))
+		for _, k := range sortedMapKeys(jv) {
+			expr, err := exprFromValue(jv[k], append(loc, StringStep(k)), decimal)
 			if err != nil {
 				return nil, err
 			}
-			obj[k] = expr
+			obj.Set(k, expr)
 		}
 		return obj, nil
 	default:
-		panic("unreachable")
+		// a Go value not decoded by encoding/json, like int or []string
+		return nil, locate(tperr.InvalidArgError().WithDetail("unsupported type %T", jv), loc, "")
 	}
 }
 
 func parseFnCall(arr Array) (FnCall, error) {
 	// arr[0] is name of function, arr[1:] are arguments
 	if len(arr) < 2 {
-		return FnCall{}, tperr.InvalidFnCallError()
+		return FnCall{}, tperr.InvalidFnCallError().WithDetail("%s is called without arguments", arr[0])
 	}
 	return FnCall{
 		FnRef: arr[0].(FnRef),
@@ -84,12 +188,12 @@ func parseFnCall(arr Array) (FnCall, error) {
 func parseTenpenFn(arr Array) (TenpenFn, error) {
 	// arr[0] is function name "def", arr[1] is string arguments, arr[2] is body
 	if len(arr) != 3 || arr[1].Type() != ExprArray {
-		return TenpenFn{}, tperr.InvalidFnDefError()
+		return TenpenFn{}, tperr.InvalidFnDefError().WithDetail("expects [\"$def\", [args...], body]")
 	}
 	args := make([]String, 0, len(arr[1].(Array)))
 	for _, arg := range arr[1].(Array) {
 		if arg.Type() != ExprString {
-			return TenpenFn{}, tperr.InvalidFnDefError()
+			return TenpenFn{}, tperr.InvalidFnDefError().WithDetail("argument name should be string, got %s", arg.Type())
 		}
 		args = append(args, arg.(String))
 	}
@@ -99,9 +203,74 @@ func parseTenpenFn(arr Array) (TenpenFn, error) {
 	}, nil
 }
 
+// ExprToBytes serializes expr into json, the keys of objects are in order.
 func ExprToBytes(expr Expr) ([]byte, error) {
-	jv := ExprToValue(expr)
-	return json.Marshal(jv)
+	return appendJSON(nil, expr, false)
+}
+
+// ExprToSortedBytes is like ExprToBytes, but the keys of objects are sorted.
+func ExprToSortedBytes(expr Expr) ([]byte, error) {
+	return appendJSON(nil, expr, true)
+}
+
+// appendJSON appends the json of expr to b. The containers are written here to
+// keep the order of keys, and the others are marshaled by encoding/json.
+func appendJSON(b []byte, expr Expr, sorted bool) ([]byte, error) {
+	var err error
+	switch expr := expr.(type) {
+	case Array:
+		b = append(b, '[')
+		for i, v := range expr {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			if b, err = appendJSON(b, v, sorted); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, ']'), nil
+	case Object:
+		var keys []string
+		for k := range expr.shown() {
+			keys = append(keys, k)
+		}
+		if sorted {
+			slices.Sort(keys)
+		}
+		b = append(b, '{')
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			if b, err = appendJSON(b, String(k), sorted); err != nil {
+				return nil, err
+			}
+			b = append(b, ':')
+			v, _ := expr.Get(k)
+			if b, err = appendJSON(b, v, sorted); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, '}'), nil
+	case FnCall:
+		return appendJSON(b, append(Array{expr.FnRef}, expr.Args...), sorted)
+	case TenpenFn:
+		args := make(Array, 0, len(expr.Args))
+		for _, arg := range expr.Args {
+			args = append(args, arg)
+		}
+		return appendJSON(b, Array{String("$def"), args, expr.Body}, sorted)
+	case Block:
+		return appendJSON(b, expr.Expr, sorted)
+	case *Program:
+		return appendJSON(b, expr.Expr, sorted)
+	default:
+		data, err := json.Marshal(ExprToValue(expr))
+		if err != nil {
+			return nil, err
+		}
+		return append(b, data...), nil
+	}
 }
 
 func ExprToValue(expr Expr) any {
@@ -112,6 +281,10 @@ func ExprToValue(expr Expr) any {
 		return string(expr)
 	case Number:
 		return float64(expr)
+	case Int:
+		return int64(expr)
+	case Decimal:
+		return json.Number(expr.Text())
 	case Bool:
 		return bool(expr)
 	case Array:
@@ -121,8 +294,8 @@ func ExprToValue(expr Expr) any {
 		}
 		return arr
 	case Object:
-		obj := make(map[string]any, len(expr))
-		for k, v := range expr {
+		obj := make(map[string]any, expr.Len())
+		for k, v := range expr.shown() {
 			obj[k] = ExprToValue(v)
 		}
 		return obj
@@ -143,12 +316,18 @@ func ExprToValue(expr Expr) any {
 			args = append(args, string(arg))
 		}
 		return []any{
-			"#def",
+			"$def",
 			args,
 			ExprToValue(expr.Body),
 		}
+	case Block:
+		return ExprToValue(expr.Expr)
+	case *Program:
+		return ExprToValue(expr.Expr)
 	case GoFn:
 		return "<GoFn>"
+	case Form:
+		return "<Form>"
 	default:
 		panic("unreachable")
 	}
@@ -0,0 +1,267 @@
+package lg
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// All the string functions are unicode-aware, lengths and indices count in
+// runes rather than bytes.
+
+func stringArgs(args []Expr, n int) ([]string, error) {
+	if len(args) != n {
+		return nil, arityError(n, len(args))
+	}
+	strs := make([]string, 0, n)
+	for _, arg := range args {
+		s, err := as[String](arg)
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, string(s))
+	}
+	return strs, nil
+}
+
+func intArg(arg Expr) (int, error) {
+	n, ok, err := toInt(arg)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || n != int64(int(n)) {
+		return 0, tperr.InvalidArgError().WithDetail("expects integer, got %s", numberText(arg))
+	}
+	return int(n), nil
+}
+
+// concat: ["$+", str1, str2, ...]
+func concat(args []Expr) (Expr, error) {
+	var b strings.Builder
+	for _, arg := range args {
+		s, err := as[String](arg)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(string(s))
+	}
+	return String(b.String()), nil
+}
+
+// length: ["$len", value], value is a string, an array or an object.
+func length(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 1 {
+		return nil, arityError("1", len(args))
+	}
+	switch v := args[0].(type) {
+	case String:
+		return Int(utf8.RuneCountInString(string(v))), nil
+	case Array:
+		return Int(len(v)), nil
+	case Object:
+		return Int(v.Len()), nil
+	default:
+		return nil, typeError("string, array or object", args[0])
+	}
+}
+
+// split: ["$split", str, sep]
+func split(e Evaller, args []Expr) (Expr, error) {
+	strs, err := stringArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(strs[0], strs[1])
+	arr := make(Array, 0, len(parts))
+	for _, p := range parts {
+		arr = append(arr, String(p))
+	}
+	return arr, nil
+}
+
+// join: ["$join", [str1, str2, ...], sep]
+func join(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 2 {
+		return nil, arityError("2", len(args))
+	}
+	arr, err := as[Array](args[0])
+	if err != nil {
+		return nil, err
+	}
+	sep, err := as[String](args[1])
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, 0, len(arr))
+	for _, v := range arr {
+		s, err := as[String](v)
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, string(s))
+	}
+	return String(strings.Join(strs, string(sep))), nil
+}
+
+// upper: ["$upper", str]
+func upper(e Evaller, args []Expr) (Expr, error) {
+	strs, err := stringArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return String(strings.ToUpper(strs[0])), nil
+}
+
+// lower: ["$lower", str]
+func lower(e Evaller, args []Expr) (Expr, error) {
+	strs, err := stringArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return String(strings.ToLower(strs[0])), nil
+}
+
+// trim: ["$trim", str, cutset], trims white spaces if cutset is omitted.
+func trim(e Evaller, args []Expr) (Expr, error) {
+	if len(args) == 1 {
+		strs, err := stringArgs(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return String(strings.TrimSpace(strs[0])), nil
+	}
+	strs, err := stringArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	return String(strings.Trim(strs[0], strs[1])), nil
+}
+
+// substr: ["$substr", str, start, length], a negative start counts from the
+// end, and the rest of string is taken if length is omitted. The range is
+// clamped to the string.
+func substr(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, arityError("2 or 3", len(args))
+	}
+	s, err := as[String](args[0])
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(string(s))
+	start, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		start += len(runes)
+	}
+	start = max(0, min(start, len(runes)))
+	end := len(runes)
+	if len(args) == 3 {
+		n, err := intArg(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, tperr.InvalidArgError().WithDetail("negative length %d", n)
+		}
+		end = start + min(n, len(runes)-start)
+	}
+	return String(runes[start:end]), nil
+}
+
+// startsWith: ["$starts-with", str, prefix]
+func startsWith(e Evaller, args []Expr) (Expr, error) {
+	strs, err := stringArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	return Bool(strings.HasPrefix(strs[0], strs[1])), nil
+}
+
+// endsWith: ["$ends-with", str, suffix]
+func endsWith(e Evaller, args []Expr) (Expr, error) {
+	strs, err := stringArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	return Bool(strings.HasSuffix(strs[0], strs[1])), nil
+}
+
+// contains: ["$contains", str, substr]
+func contains(e Evaller, args []Expr) (Expr, error) {
+	strs, err := stringArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	return Bool(strings.Contains(strs[0], strs[1])), nil
+}
+
+// replace: ["$replace", str, old, new], replaces all the occurrences.
+func replace(e Evaller, args []Expr) (Expr, error) {
+	strs, err := stringArgs(args, 3)
+	if err != nil {
+		return nil, err
+	}
+	if n := strings.Count(strs[0], strs[1]); n > 0 {
+		if err := allowSize(e, len(strs[0])+n*(len(strs[2])-len(strs[1]))); err != nil {
+			return nil, err
+		}
+	}
+	return String(strings.ReplaceAll(strs[0], strs[1], strs[2])), nil
+}
+
+// maxPadWidth is the max width of pad, which bounds the padded string even if
+// there are no limits.
+const maxPadWidth = 1 << 20
+
+// pad: ["$pad", str, width, fill], pads str to width runes with fill, which is
+// a space if omitted. It pads on the left, or on the right if width is
+// negative, the width is within ±maxPadWidth.
+func pad(e Evaller, args []Expr) (Expr, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, arityError("2 or 3", len(args))
+	}
+	s, err := as[String](args[0])
+	if err != nil {
+		return nil, err
+	}
+	width, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if width > maxPadWidth || width < -maxPadWidth {
+		return nil, tperr.InvalidArgError().WithDetail("width %d is out of range ±%d", width, maxPadWidth)
+	}
+	fill := " "
+	if len(args) == 3 {
+		f, err := as[String](args[2])
+		if err != nil {
+			return nil, err
+		}
+		if utf8.RuneCountInString(string(f)) != 1 {
+			return nil, tperr.InvalidArgError().WithDetail("expects a single character fill, got %q", f)
+		}
+		fill = string(f)
+	}
+	left := width > 0
+	if width < 0 {
+		width = -width
+	}
+	n := width - utf8.RuneCountInString(string(s))
+	if n <= 0 {
+		return s, nil
+	}
+	if err := allowSize(e, n); err != nil { // checked in runes first, so the bytes don't overflow
+		return nil, err
+	}
+	if err := allowSize(e, len(s)+n*len(fill)); err != nil {
+		return nil, err
+	}
+	if left {
+		return String(strings.Repeat(fill, n) + string(s)), nil
+	}
+	return String(string(s) + strings.Repeat(fill, n)), nil
+}
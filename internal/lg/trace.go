@@ -0,0 +1,131 @@
+package lg
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Trace is a step of an evaluation, an expression evaluated at Path, with the
+// steps it consists of.
+type Trace struct {
+	Path   Path
+	Expr   Expr
+	Result Expr   // Result is nil if the step fails
+	Err    error  // Err is the error of the step
+	Source string // Source is where a value reference is found, like "env 0"
+	Fn     string // Fn is the function called, like "$+"
+	Args   []Expr // Args are the evaluated arguments, nil for a form
+	Steps  []*Trace
+}
+
+// tracer records the steps of an evaluation, it's shared by the evaluator and
+// its sub evaluators.
+type tracer struct {
+	root  *Trace
+	stack []*Trace // stack is the steps being evaluated
+}
+
+func (t *tracer) begin(loc Path, expr Expr) *Trace {
+	step := &Trace{Path: slices.Clone(loc), Expr: expr}
+	if len(t.stack) == 0 {
+		t.root = step
+	} else {
+		parent := t.current()
+		parent.Steps = append(parent.Steps, step)
+	}
+	t.stack = append(t.stack, step)
+	return step
+}
+
+func (t *tracer) end(step *Trace, result Expr, err error) {
+	step.Result, step.Err = result, err
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *tracer) current() *Trace {
+	return t.stack[len(t.stack)-1]
+}
+
+// WithTrace makes the evaluator record the steps of evaluation, they are got
+// by Trace after Eval.
+func (e *Evaluator) WithTrace() *Evaluator {
+	e.trace = &tracer{}
+	return e
+}
+
+// Trace returns the steps recorded by the evaluator, it's nil if the evaluator
+// is not traced.
+func (e *Evaluator) Trace() *Trace {
+	if e.trace == nil {
+		return nil
+	}
+	return e.trace.root
+}
+
+// traceVal gets the value of a reference, and records where it's found.
+func (e *Evaluator) traceVal(loc Path) (Expr, error) {
+	v, layer, err := e.lookup(loc)
+	if err != nil {
+		return nil, err
+	}
+	e.trace.current().Source = e.layerName(layer)
+	return v, nil
+}
+
+// layerName returns the name of the layer i of the value stack. The
+// environments are "env 0", "env 1"..., and a scope of function arguments or
+// let bindings is "scope".
+func (e *Evaluator) layerName(i int) string {
+	switch {
+	case i < 0:
+		return "source"
+	case i < e.envs:
+		return fmt.Sprintf("env %d", i)
+	case i == e.envs:
+		return "rule"
+	default:
+		return "scope"
+	}
+}
+
+type traceJSON struct {
+	Path   string            `json:"path,omitempty"`
+	Expr   json.RawMessage   `json:"expr"`
+	Source string            `json:"source,omitempty"`
+	Fn     string            `json:"fn,omitempty"`
+	Args   []json.RawMessage `json:"args,omitempty"`
+	Result json.RawMessage   `json:"result,omitempty"`
+	Error  string            `json:"error,omitempty"`
+	Steps  []*Trace          `json:"steps,omitempty"`
+}
+
+// MarshalJSON encodes the trace as a tree, the expressions are in the json of
+// rule.
+func (t *Trace) MarshalJSON() ([]byte, error) {
+	out := traceJSON{
+		Path:   t.Path.String(),
+		Expr:   traceExpr(t.Expr),
+		Source: t.Source,
+		Fn:     t.Fn,
+		Steps:  t.Steps,
+	}
+	for _, arg := range t.Args {
+		out.Args = append(out.Args, traceExpr(arg))
+	}
+	if t.Result != nil {
+		out.Result = traceExpr(t.Result)
+	}
+	if t.Err != nil {
+		out.Error = t.Err.Error()
+	}
+	return json.Marshal(out)
+}
+
+func traceExpr(expr Expr) json.RawMessage {
+	data, err := ExprToBytes(expr)
+	if err != nil {
+		data, _ = json.Marshal(expr.String())
+	}
+	return data
+}
@@ -0,0 +1,607 @@
+package lg
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// fnInfo is what's known about a function of a function type.
+type fnInfo struct {
+	builtin string     // builtin is the name of a builtin function
+	sig     *Signature // sig is the signature of a function added with it
+	def     *TenpenFn  // def is the function defined by "$def" in rule
+	at      Path       // at is the location of def in rule
+}
+
+// The parameters of the builtin functions, the forms and the higher-order
+// functions are checked by code. The arity is checked by builtinArity.
+var (
+	pathType       = Type{Kind: StringKind | NumberKind}
+	numberOrString = pathType
+	arrayType      = Type{Kind: ArrayKind}
+	objectType     = Type{Kind: ObjectKind}
+	numbersSig     = Signature{Params: []Type{NumberType}, Variadic: true, Result: NumberType}
+	equalSig       = Signature{Params: []Type{AnyType}, Variadic: true, Result: BoolType}
+	prefixSig      = Signature{Params: []Type{StringType, StringType}, Result: BoolType}
+	caseSig        = Signature{Params: []Type{StringType}, Result: StringType}
+	mergeSig       = Signature{Params: []Type{objectType}, Variadic: true, Result: objectType}
+	filterKeySig   = Signature{Params: []Type{objectType, arrayType}, Result: objectType}
+	arrayFnSig     = Signature{Params: []Type{arrayType, FnType, AnyType}, Optional: 1}
+)
+
+var builtinSigs = map[string]Signature{
+	"-":            numbersSig,
+	"*":            numbersSig,
+	"/":            numbersSig,
+	"round":        {Params: []Type{NumberType, NumberType}, Optional: 1, Result: NumberType},
+	"not":          {Params: []Type{BoolType}, Result: BoolType},
+	"==":           equalSig,
+	"!=":           equalSig,
+	"apply":        {Params: []Type{FnType, arrayType}},
+	"map":          arrayFnSig,
+	"filter":       arrayFnSig,
+	"reduce":       arrayFnSig,
+	"some":         arrayFnSig,
+	"every":        arrayFnSig,
+	"find":         arrayFnSig,
+	"len":          {Params: []Type{{Kind: StringKind | ArrayKind | ObjectKind}}, Result: NumberType},
+	"split":        {Params: []Type{StringType, StringType}, Result: ArrayOf(StringType)},
+	"join":         {Params: []Type{ArrayOf(StringType), StringType}, Result: StringType},
+	"upper":        caseSig,
+	"lower":        caseSig,
+	"trim":         {Params: []Type{StringType, StringType}, Optional: 1, Result: StringType},
+	"substr":       {Params: []Type{StringType, NumberType, NumberType}, Optional: 1, Result: StringType},
+	"starts-with":  prefixSig,
+	"ends-with":    prefixSig,
+	"contains":     prefixSig,
+	"replace":      {Params: []Type{StringType, StringType, StringType}, Result: StringType},
+	"pad":          {Params: []Type{StringType, NumberType, StringType}, Optional: 1, Result: StringType},
+	"keys":         {Params: []Type{objectType}, Result: ArrayOf(StringType)},
+	"values":       {Params: []Type{objectType}, Result: arrayType},
+	"get":          {Params: []Type{AnyType, pathType, AnyType}, Optional: 1},
+	"merge":        mergeSig,
+	"deep-merge":   mergeSig,
+	"pick":         filterKeySig,
+	"omit":         filterKeySig,
+	"entries":      {Params: []Type{objectType}, Result: ArrayOf(arrayType)},
+	"from-entries": {Params: []Type{arrayType}, Result: objectType},
+	"set":          {Params: []Type{AnyType, pathType, AnyType}},
+}
+
+// maxInstances caps the times the function bodies are inferred, the result of
+// a call is any after it.
+const maxInstances = 10000
+
+// TypeCheck infers the type of the rule expr without evaluating it, and
+// reports the problems: the arguments of wrong types, the calls of wrong arity
+// and the references to functions that don't exist. facts is the type of the
+// environments, the value references missing from it are reported too, unless
+// it's any. sigs are the signatures of the functions by name, like
+// "str.upper" for a function of module. A function defined by "$def" is
+// inferred at every call with the types of arguments, as it's evaluated in the
+// scope of caller.
+//
+// The problems that fail the evaluation whenever the expression runs are
+// errors. The ones that may never fail it are warnings: the problems in a
+// branch that's never taken, like the then of an "$if" of a literal false, and
+// the values that may be null where null is not expected. Both are joined.
+func TypeCheck(expr Expr, facts Type, functions []Expr, sigs map[string]Signature) (t Type, warnings, err error) {
+	c := &checker{
+		facts:     facts,
+		functions: functions,
+		sigs:      sigs,
+		active:    map[string]bool{},
+		defs:      map[string]struct{}{},
+		seen:      map[string]struct{}{},
+	}
+	rule := newScope(nil, expr, nil, Path{})
+	t = c.typeOf(rule, Path{}, expr)
+	return t, errors.Join(c.warns...), errors.Join(c.errs...)
+}
+
+type checker struct {
+	facts     Type
+	functions []Expr
+	sigs      map[string]Signature
+	active    map[string]bool     // active are the functions being inferred by location
+	defs      map[string]struct{} // defs are the functions checked by location
+	instances int
+	seen      map[string]struct{} // seen are the problems reported, to report each once
+	dead      int                 // dead is positive in a branch that's never taken
+	errs      []error
+	warns     []error
+}
+
+// scope is a layer of values like the one of a sub evaluator. root is the
+// expression evaluated in the layer, and vars are the arguments of function.
+type scope struct {
+	parent *scope
+	root   Expr
+	vars   map[string]Type
+	at     Path            // at is the location of root in rule
+	types  map[string]Type // types are the inferred types by location
+	busy   map[string]bool // busy are the locations being inferred
+}
+
+func newScope(parent *scope, root Expr, vars map[string]Type, at Path) *scope {
+	return &scope{
+		parent: parent,
+		root:   root,
+		vars:   vars,
+		at:     slices.Clone(at),
+		types:  map[string]Type{},
+		busy:   map[string]bool{},
+	}
+}
+
+// report reports a problem, it's a warning in a branch that's never taken.
+func (c *checker) report(err *tperr.Error, at Path, expr Expr, ref FnRef) {
+	c.add(err, at, expr, ref, c.dead > 0)
+}
+
+// warn reports a problem that may not fail the evaluation.
+func (c *checker) warn(err *tperr.Error, at Path, expr Expr, ref FnRef) {
+	c.add(err, at, expr, ref, true)
+}
+
+func (c *checker) add(err *tperr.Error, at Path, expr Expr, ref FnRef, warning bool) {
+	if ref != nil {
+		_ = inFn(err, ref)
+	}
+	_ = locate(err, at, expr.String())
+	key := err.Error()
+	if warning {
+		key = "warning " + key
+	}
+	if _, ok := c.seen[key]; ok {
+		return
+	}
+	c.seen[key] = struct{}{}
+	if warning {
+		c.warns = append(c.warns, err)
+	} else {
+		c.errs = append(c.errs, err)
+	}
+}
+
+// expect reports if a value of t can never be of want, and warns if it may be
+// null where want is not.
+func (c *checker) expect(t, want Type, at Path, expr Expr, ref FnRef) {
+	err := tperr.InvalidTypeError().WithDetail("expects %s, got %s", want.describe(), t.describe())
+	switch {
+	case !assignable(t, want):
+		c.report(err, at, expr, ref)
+	case t.Nullable && t.Kind != NullKind && t.Kind != AnyKind && want.Kind != AnyKind &&
+		!want.Nullable && want.Kind&NullKind == 0:
+		c.warn(err, at, expr, ref)
+	}
+}
+
+// branch marks the checks until done as in a branch that's never taken if
+// dead is true.
+func (c *checker) branch(dead bool) (done func()) {
+	if !dead {
+		return func() {}
+	}
+	c.dead++
+	return func() { c.dead-- }
+}
+
+// literalBool returns the value of a condition that's a literal bool, ok is
+// false if it's decided at runtime.
+func literalBool(expr Expr) (value, ok bool) {
+	b, ok := expr.(Bool)
+	return bool(b), ok
+}
+
+// typeOf returns the type of expr at location at of scope s, it's inferred once.
+func (c *checker) typeOf(s *scope, at Path, expr Expr) Type {
+	key := at.String()
+	if t, ok := s.types[key]; ok {
+		return t
+	}
+	if s.busy[key] {
+		return AnyType // a circular reference, it's reported by CheckCycles
+	}
+	s.busy[key] = true
+	t := c.infer(s, expr, at)
+	delete(s.busy, key)
+	s.types[key] = t
+	return t
+}
+
+func (c *checker) infer(s *scope, expr Expr, at Path) Type {
+	switch expr := expr.(type) {
+	case Object:
+		keys := make([]string, 0, expr.Len())
+		types := make(map[string]Type, expr.Len())
+		for k, v := range expr.All() {
+			keys = append(keys, k)
+			types[k] = c.typeOf(s, append(at, StringStep(k)), v)
+		}
+		return ObjectType(keys, types)
+	case Array:
+		if len(expr) == 0 {
+			return arrayType
+		}
+		elem := c.typeOf(s, append(at, NumberStep(0)), expr[0])
+		for i, v := range expr[1:] {
+			elem = joinTypes(elem, c.typeOf(s, append(at, NumberStep(i+1)), v))
+		}
+		return ArrayOf(elem)
+	case ValRef:
+		return c.valRef(s, Path(expr), at, expr)
+	case FnRef:
+		return c.fnRef(s, Path(expr), at, expr)
+	case FnCall:
+		return c.call(s, expr, at)
+	case TenpenFn:
+		c.checkDef(s, expr, at)
+		return Type{Kind: FnKind, fn: &fnInfo{def: &expr, at: slices.Clone(at)}}
+	default:
+		return typeOfValue(expr)
+	}
+}
+
+// typeIn returns the type at path of the values in scope s, ok is false if it's
+// not in s.
+func (c *checker) typeIn(s *scope, path Path) (Type, bool) {
+	expr, at := s.root, slices.Clone(s.at)
+	for i, step := range path {
+		switch v := expr.(type) {
+		case Object:
+			key, ok := step.(StringStep)
+			if !ok {
+				return AnyType, false
+			}
+			if expr, ok = v.Get(string(key)); !ok {
+				return AnyType, false
+			}
+		case Array:
+			n, ok := step.(NumberStep)
+			if !ok || n < 0 || int(n) >= len(v) {
+				return AnyType, false
+			}
+			expr = v[n]
+		default:
+			if s.busy[at.String()] {
+				return AnyType, false // it's not evaluated yet, like the body of function
+			}
+			return c.typeOf(s, at, expr).in(path[i:])
+		}
+		at = append(at, step)
+	}
+	return c.typeOf(s, at, expr), true
+}
+
+// valRef returns the type of the value at path, it's looked up like
+// Evaluator.lookup, from the top scope to the facts.
+func (c *checker) valRef(s *scope, path Path, at Path, expr Expr) Type {
+	for ; s != nil; s = s.parent {
+		if t, ok := c.typeIn(s, path); ok {
+			return t
+		}
+		if key, ok := path[0].(StringStep); ok {
+			if v, ok := s.vars[string(key)]; ok {
+				if t, ok := v.in(path[1:]); ok {
+					return t
+				}
+			}
+		}
+	}
+	if t, ok := c.facts.in(path); ok {
+		return t
+	}
+	c.report(tperr.NoRefError().WithDetail("%s is not found", ValRef(path)), at, expr, nil)
+	return AnyType
+}
+
+// fnRef returns the type of the function at path, it's looked up like
+// Evaluator.getFn, from the functions defined in scopes to the ones of engine.
+func (c *checker) fnRef(s *scope, path Path, at Path, expr Expr) Type {
+	for ; s != nil; s = s.parent {
+		if t, ok := c.typeIn(s, path); ok && (t.Kind == FnKind || t.Kind == AnyKind) {
+			return t
+		}
+	}
+	for i := len(c.functions) - 1; i >= 0; i-- {
+		if v, err := path.GetFrom(c.functions[i]); err == nil && v.Type() == ExprFn {
+			info := &fnInfo{}
+			if i == 0 && len(path) == 1 {
+				info.builtin = path[0].String()
+			} else if sig, ok := c.sigs[path.String()]; ok {
+				info.sig = &sig
+			}
+			return Type{Kind: FnKind, fn: info}
+		}
+	}
+	c.report(tperr.NoRefError().WithDetail("%s is not found", FnRef(path)), at, expr, nil)
+	return AnyType
+}
+
+// checkDef checks the body of a function once with arguments of any types, so
+// a function is checked even if it's not called.
+func (c *checker) checkDef(s *scope, def TenpenFn, at Path) {
+	key := at.String()
+	if _, ok := c.defs[key]; ok {
+		return
+	}
+	c.defs[key] = struct{}{}
+	vars := make(map[string]Type, len(def.Args))
+	for _, arg := range def.Args {
+		vars[string(arg)] = AnyType
+	}
+	c.active[key] = true
+	c.typeOf(newScope(s, def.Body, vars, at), at, def.Body)
+	delete(c.active, key)
+}
+
+// callDef infers the result of calling a function defined in rule by the
+// caller in scope s. The missing arguments are null like TenpenFn.Apply.
+func (c *checker) callDef(s *scope, fn *fnInfo, args []Type) Type {
+	key := fn.at.String()
+	if c.active[key] || c.instances >= maxInstances {
+		return AnyType
+	}
+	c.instances++
+	vars := make(map[string]Type, len(fn.def.Args))
+	for i, arg := range fn.def.Args {
+		vars[string(arg)] = NullType
+		if i < len(args) {
+			vars[string(arg)] = args[i]
+		}
+	}
+	c.active[key] = true
+	defer delete(c.active, key)
+	return c.typeOf(newScope(s, fn.def.Body, vars, fn.at), fn.at, fn.def.Body)
+}
+
+func (c *checker) call(s *scope, call FnCall, at Path) Type {
+	fn := c.fnRef(s, Path(call.FnRef), at, call)
+	if fn.fn != nil && fn.fn.builtin != "" {
+		if a, ok := builtinArity[fn.fn.builtin]; ok && !a.accepts(len(call.Args)) {
+			c.report(arityError(a, len(call.Args)), at, call, call.FnRef)
+			c.inferArgs(s, call.Args, at)
+			return AnyType
+		}
+		if t, ok := c.form(s, fn.fn.builtin, call, at); ok {
+			return t
+		}
+	}
+	args := c.inferArgs(s, call.Args, at)
+	if fn.fn != nil && fn.fn.def != nil && len(args) != len(fn.fn.def.Args) {
+		c.report(arityError(len(fn.fn.def.Args), len(args)), at, call, call.FnRef)
+		for len(args) < len(fn.fn.def.Args) {
+			args = append(args, AnyType) // the arity is reported already
+		}
+	}
+	return c.apply(s, fn, args, at, call, call.FnRef)
+}
+
+func (c *checker) inferArgs(s *scope, args []Expr, at Path) []Type {
+	types := make([]Type, 0, len(args))
+	for i, arg := range args {
+		types = append(types, c.infer(s, arg, append(at, NumberStep(i))))
+	}
+	return types
+}
+
+// apply infers the result of calling fn with arguments of types args.
+func (c *checker) apply(s *scope, fn Type, args []Type, at Path, expr Expr, ref FnRef) Type {
+	switch {
+	case fn.fn == nil:
+		return AnyType
+	case fn.fn.def != nil:
+		return c.callDef(s, fn.fn, args)
+	case fn.fn.sig != nil:
+		if a := fn.fn.sig.arity(); !a.accepts(len(args)) {
+			c.report(arityError(a, len(args)), at, expr, ref)
+		}
+		c.checkArgs(*fn.fn.sig, args, at, expr, ref)
+		return fn.fn.sig.Result
+	default:
+		return c.builtin(s, fn.fn.builtin, args, at, expr, ref)
+	}
+}
+
+func (c *checker) checkArgs(sig Signature, args []Type, at Path, expr Expr, ref FnRef) {
+	for i, t := range args {
+		if want, ok := sig.param(i); ok {
+			c.expect(t, want, at, expr, ref)
+		}
+	}
+}
+
+// form infers the result of a builtin form, ok is false if name is not a form.
+// The arguments of a form are inferred in the scope of call.
+func (c *checker) form(s *scope, name string, call FnCall, at Path) (t Type, ok bool) {
+	arg := func(i int) Type {
+		return c.infer(s, call.Args[i], append(at, NumberStep(i)))
+	}
+	switch name {
+	case "and", "or":
+		// the arguments after a literal false of and, or after a literal
+		// true of or, are never evaluated
+		dead := false
+		for i := range call.Args {
+			done := c.branch(dead)
+			c.expect(arg(i), BoolType, at, call, call.FnRef)
+			done()
+			if v, ok := literalBool(call.Args[i]); ok && v == (name == "or") {
+				dead = true
+			}
+		}
+		return BoolType, true
+	case "if":
+		c.expect(arg(0), BoolType, at, call, call.FnRef)
+		cond, known := literalBool(call.Args[0])
+		done := c.branch(known && !cond)
+		t = arg(1)
+		done()
+		if len(call.Args) == 3 {
+			done := c.branch(known && cond)
+			t = joinTypes(t, arg(2))
+			done()
+			return t, true
+		}
+		return joinTypes(t, NullType), true
+	case "cond":
+		// the branches after a literal true condition are never taken
+		var branches []Type
+		dead := false
+		for i := 0; i+1 < len(call.Args); i += 2 {
+			done := c.branch(dead)
+			c.expect(arg(i), BoolType, at, call, call.FnRef)
+			v, known := literalBool(call.Args[i])
+			taken := c.branch(known && !v)
+			branches = append(branches, arg(i+1))
+			taken()
+			done()
+			dead = dead || known && v
+		}
+		done := c.branch(dead)
+		if len(call.Args)%2 == 1 {
+			branches = append(branches, arg(len(call.Args)-1))
+		} else {
+			branches = append(branches, NullType)
+		}
+		done()
+		t = branches[0]
+		for _, b := range branches[1:] {
+			t = joinTypes(t, b)
+		}
+		return t, true
+	case "let":
+		bindings, isObj := call.Args[0].(Object)
+		if !isObj {
+			c.report(typeError("object", call.Args[0]), at, call, call.FnRef)
+			return AnyType, true
+		}
+		bindingsAt := append(slices.Clone(at), NumberStep(0))
+		scope := newScope(s, bindings, nil, bindingsAt)
+		c.typeOf(scope, bindingsAt, bindings)
+		bodyAt := append(slices.Clone(at), NumberStep(1))
+		return c.typeOf(newScope(scope, call.Args[1], nil, bodyAt), bodyAt, call.Args[1]), true
+	case "do":
+		t = NullType
+		for i := range call.Args {
+			t = arg(i)
+		}
+		return t, true
+	}
+	return AnyType, false
+}
+
+// builtin infers the result of calling a builtin function, which is not a form.
+func (c *checker) builtin(s *scope, name string, args []Type, at Path, expr Expr, ref FnRef) Type {
+	switch name {
+	case "+":
+		// the first argument decides whether the strings are concatenated
+		return c.sameKind(args, NumberType, at, expr, ref)
+	case ">", "<", ">=", "<=":
+		c.sameKind(args, numberOrString, at, expr, ref)
+		return BoolType
+	}
+	sig, ok := builtinSigs[name]
+	if !ok {
+		return AnyType // a form called by a higher-order function
+	}
+	c.checkArgs(sig, args, at, expr, ref)
+	switch name {
+	case "map", "filter", "reduce", "some", "every", "find":
+		return c.arrayFn(s, name, args, at, expr, ref)
+	case "apply":
+		if fn := args[0]; fn.fn != nil && fn.fn.sig != nil {
+			return fn.fn.sig.Result
+		}
+	}
+	return sig.Result
+}
+
+// sameKind checks the arguments are all numbers or all strings, which is
+// decided by the first known one, or they are expected to be other. It returns
+// the type of the arguments.
+func (c *checker) sameKind(args []Type, other Type, at Path, expr Expr, ref FnRef) Type {
+	want := numberOrString
+	for _, t := range args {
+		if t.Kind == NumberKind || t.Kind == StringKind {
+			want = Type{Kind: t.Kind}
+			break
+		}
+		if t.Kind != AnyKind {
+			want = other
+			break
+		}
+	}
+	for _, t := range args {
+		c.expect(t, want, at, expr, ref)
+	}
+	if want.Kind == numberOrString.Kind {
+		return AnyType
+	}
+	return want
+}
+
+// arrayFn infers the result of a higher-order function, its callback is called
+// with the element and the index, and the accumulator goes first for reduce.
+func (c *checker) arrayFn(s *scope, name string, args []Type, at Path, expr Expr, ref FnRef) Type {
+	elem := args[0].Elem()
+	if name == "reduce" {
+		acc := elem
+		if len(args) == 3 {
+			acc = args[2]
+		}
+		return joinTypes(acc, c.callback(s, args[1], 2, []Type{acc, elem, NumberType}, at, expr, ref))
+	}
+	result := c.callback(s, args[1], 1, []Type{elem, NumberType}, at, expr, ref)
+	switch name {
+	case "map":
+		return ArrayOf(result)
+	case "filter":
+		c.expect(result, BoolType, at, expr, ref)
+		if args[0].Kind == ArrayKind {
+			return args[0]
+		}
+		return arrayType
+	case "find":
+		c.expect(result, BoolType, at, expr, ref)
+		return joinTypes(elem, NullType)
+	default:
+		c.expect(result, BoolType, at, expr, ref)
+		return BoolType
+	}
+}
+
+// callback infers the result of calling fn by a higher-order function, a
+// function not defined in rule only receives the leading arguments.
+func (c *checker) callback(s *scope, fn Type, leading int, args []Type, at Path, expr Expr, ref FnRef) Type {
+	if fn.fn != nil && fn.fn.def == nil {
+		args = args[:leading]
+		if fn.fn.builtin != "" {
+			if a, ok := builtinArity[fn.fn.builtin]; ok && !a.accepts(leading) {
+				c.report(arityError(a, leading), at, expr, ref)
+				return AnyType
+			}
+		}
+	}
+	return c.apply(s, fn, args, at, expr, ref)
+}
+
+// assignable reports whether a value of t may be of want, it's false only if
+// they are incompatible for sure.
+func assignable(t, want Type) bool {
+	switch {
+	case t.Kind == AnyKind || want.Kind == AnyKind:
+		return true
+	case t.Kind == NullKind:
+		return want.Nullable || want.Kind&NullKind != 0
+	case t.Kind&want.Kind == 0:
+		return false
+	case t.Kind == ArrayKind && want.elem != nil:
+		return assignable(t.Elem(), want.Elem())
+	default:
+		return true
+	}
+}
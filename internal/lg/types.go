@@ -0,0 +1,347 @@
+package lg
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// Kind is the kind of a static type. The kinds are bits, so a set of them is
+// a Kind too, like NumberKind|StringKind.
+type Kind uint8
+
+const (
+	NullKind Kind = 1 << iota
+	BoolKind
+	NumberKind
+	StringKind
+	ArrayKind
+	ObjectKind
+	FnKind
+	AnyKind Kind = 0 // AnyKind is unknown, it's compatible with every kind
+)
+
+var kindNames = []struct {
+	kind Kind
+	name string
+}{
+	{NullKind, "null"}, {BoolKind, "bool"}, {NumberKind, "number"}, {StringKind, "string"},
+	{ArrayKind, "array"}, {ObjectKind, "object"}, {FnKind, "function"},
+}
+
+// String returns the name of kind, a set of kinds is like "string, array or
+// object".
+func (k Kind) String() string {
+	var names []string
+	for _, kn := range kindNames {
+		if k&kn.kind != 0 {
+			names = append(names, kn.name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "any"
+	case 1:
+		return names[0]
+	default:
+		return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+	}
+}
+
+// has reports whether a value of kind k is in the set of kinds.
+func (k Kind) has(set Kind) bool {
+	return k == AnyKind || set == AnyKind || k&set != 0
+}
+
+// Type is the static type of values. The zero Type is any, which is
+// compatible with every type.
+type Type struct {
+	Kind     Kind
+	Nullable bool      // Nullable means the value may be null too
+	elem     *Type     // elem is the type of the elements of an array
+	fields   *fieldsOf // fields are the fields of an object, nil if unknown
+	fn       *fnInfo   // fn is the function of a function type, nil if unknown
+}
+
+// fieldsOf are the types of the fields of an object, in order.
+type fieldsOf struct {
+	keys  []string
+	types map[string]Type
+}
+
+// The types of scalar values.
+var (
+	AnyType    = Type{}
+	NullType   = Type{Kind: NullKind}
+	BoolType   = Type{Kind: BoolKind}
+	NumberType = Type{Kind: NumberKind}
+	StringType = Type{Kind: StringKind}
+	FnType     = Type{Kind: FnKind}
+)
+
+// ArrayOf returns the type of arrays of elem.
+func ArrayOf(elem Type) Type {
+	return Type{Kind: ArrayKind, elem: &elem}
+}
+
+// ObjectType returns the type of objects with the fields, the keys are in the
+// order of keys. It's an object of unknown fields if keys is nil.
+func ObjectType(keys []string, types map[string]Type) Type {
+	if keys == nil {
+		return Type{Kind: ObjectKind}
+	}
+	return Type{Kind: ObjectKind, fields: &fieldsOf{keys: keys, types: types}}
+}
+
+// Signature is the type of a function.
+type Signature struct {
+	Params   []Type // Params are the types of the parameters
+	Optional int    // Optional is the number of the trailing parameters that can be omitted
+	Variadic bool   // Variadic means the last parameter can be repeated
+	Result   Type
+}
+
+// arity returns the number of arguments s accepts.
+func (s Signature) arity() arity {
+	a := arity{min: len(s.Params) - s.Optional, max: len(s.Params)}
+	if s.Variadic {
+		a.max = -1
+	}
+	return a
+}
+
+// param returns the type of the argument i, ok is false if there is no such
+// parameter.
+func (s Signature) param(i int) (Type, bool) {
+	switch {
+	case i < len(s.Params):
+		return s.Params[i], true
+	case s.Variadic && len(s.Params) > 0:
+		return s.Params[len(s.Params)-1], true
+	default:
+		return AnyType, false
+	}
+}
+
+// Elem returns the type of the elements of an array type.
+func (t Type) Elem() Type {
+	if t.elem == nil {
+		return AnyType
+	}
+	return *t.elem
+}
+
+// Field returns the type of the field key of an object type, ok is false if
+// the object has no such field. An object of unknown fields has any field.
+func (t Type) Field(key string) (Type, bool) {
+	if t.fields == nil {
+		return AnyType, true
+	}
+	f, ok := t.fields.types[key]
+	return f, ok
+}
+
+// Keys returns the keys of an object type in order, it's nil if the fields are
+// unknown.
+func (t Type) Keys() []string {
+	if t.fields == nil {
+		return nil
+	}
+	return t.fields.keys
+}
+
+// in returns the type at path of t, ok is false if the path can't be in a
+// value of t, so it's looked up in the lower layers.
+func (t Type) in(path Path) (Type, bool) {
+	for _, step := range path {
+		switch {
+		case t.Kind == AnyKind:
+			return AnyType, true
+		case t.Kind == ObjectKind && step.StepType() == StepTypeString:
+			var ok bool
+			if t, ok = t.Field(step.String()); !ok {
+				return AnyType, false
+			}
+		case t.Kind == ArrayKind && step.StepType() == StepTypeNumber:
+			t = t.Elem()
+		default:
+			return AnyType, false
+		}
+	}
+	return t, true
+}
+
+// describe returns the type in words for messages, like "array of string".
+func (t Type) describe() string {
+	var name string
+	switch {
+	case t.Kind == ArrayKind && t.elem != nil:
+		name = "array of " + t.elem.describe()
+	default:
+		name = t.Kind.String()
+	}
+	if t.Nullable && t.Kind != NullKind && t.Kind != AnyKind {
+		name += " or null"
+	}
+	return name
+}
+
+// String returns the type in the schema format, like "number" or
+// ["string"].
+func (t Type) String() string {
+	data, err := t.MarshalJSON()
+	if err != nil {
+		return t.Kind.String()
+	}
+	return string(data)
+}
+
+// MarshalJSON returns the type in the schema format.
+func (t Type) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	t.write(&b)
+	return []byte(b.String()), nil
+}
+
+func (t Type) write(b *strings.Builder) {
+	switch {
+	case t.Kind == ArrayKind && t.elem != nil:
+		b.WriteByte('[')
+		t.elem.write(b)
+		b.WriteByte(']')
+	case t.Kind == ObjectKind && t.fields != nil:
+		b.WriteByte('{')
+		for i, k := range t.fields.keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			key, _ := json.Marshal(k)
+			b.Write(key)
+			b.WriteByte(':')
+			t.fields.types[k].write(b)
+		}
+		b.WriteByte('}')
+	default:
+		name := t.Kind.String()
+		if t.Kind == FnKind {
+			name = "fn"
+		}
+		if t.Nullable && t.Kind != NullKind && t.Kind != AnyKind {
+			name += "?"
+		}
+		b.WriteString(`"` + name + `"`)
+	}
+}
+
+// ParseType parses a type in the schema format. A type is a name like
+// "number", with "?" if it may be null, like "number?". The names are "any",
+// "null", "bool", "number", "string", "array", "object" and "fn". An array of
+// a type is like ["string"], and an object of fields is like {"a": "number"}.
+func ParseType(schema Expr) (Type, error) {
+	switch s := schema.(type) {
+	case String:
+		name, nullable := strings.CutSuffix(string(s), "?")
+		t, ok := typeNames[name]
+		if !ok {
+			return AnyType, tperr.InvalidArgError().WithDetail("unknown type %q", s)
+		}
+		t.Nullable = nullable
+		return t, nil
+	case Array:
+		if len(s) != 1 {
+			return AnyType, tperr.InvalidArgError().WithDetail("expects an array type of one element, got %d", len(s))
+		}
+		elem, err := ParseType(s[0])
+		if err != nil {
+			return AnyType, err
+		}
+		return ArrayOf(elem), nil
+	case Object:
+		keys := make([]string, 0, s.Len())
+		types := make(map[string]Type, s.Len())
+		for k, v := range s.All() {
+			t, err := ParseType(v)
+			if err != nil {
+				return AnyType, err
+			}
+			keys = append(keys, k)
+			types[k] = t
+		}
+		return ObjectType(keys, types), nil
+	default:
+		return AnyType, tperr.InvalidArgError().WithDetail("invalid type %s", schema)
+	}
+}
+
+var typeNames = map[string]Type{
+	"any": AnyType, "null": NullType, "bool": BoolType, "number": NumberType, "string": StringType,
+	"array": {Kind: ArrayKind}, "object": {Kind: ObjectKind}, "fn": FnType,
+}
+
+// joinTypes returns the type of a value that is of a or b.
+func joinTypes(a, b Type) Type {
+	switch {
+	case a.Kind == NullKind && b.Kind != AnyKind:
+		b.Nullable = true
+		return b
+	case b.Kind == NullKind && a.Kind != AnyKind:
+		a.Nullable = true
+		return a
+	case a.Kind != b.Kind || a.Kind == AnyKind:
+		return AnyType
+	}
+	nullable := a.Nullable || b.Nullable
+	switch a.Kind {
+	case ArrayKind:
+		a = ArrayOf(joinTypes(a.Elem(), b.Elem()))
+	case ObjectKind:
+		if !sameKeys(a.Keys(), b.Keys()) {
+			a = ObjectType(nil, nil)
+			break
+		}
+		types := make(map[string]Type, len(a.Keys()))
+		for _, k := range a.Keys() {
+			fa, _ := a.Field(k)
+			fb, _ := b.Field(k)
+			types[k] = joinTypes(fa, fb)
+		}
+		a = ObjectType(a.Keys(), types)
+	case FnKind:
+		a = FnType
+	}
+	a.Nullable = nullable
+	return a
+}
+
+func sameKeys(a, b []string) bool {
+	if a == nil || b == nil || len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]struct{}, len(a))
+	for _, k := range a {
+		set[k] = struct{}{}
+	}
+	for _, k := range b {
+		if _, ok := set[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// typeOfValue returns the type of a literal value.
+func typeOfValue(expr Expr) Type {
+	switch expr.(type) {
+	case Null:
+		return NullType
+	case Bool:
+		return BoolType
+	case Number, Int, Decimal:
+		return NumberType
+	case String:
+		return StringType
+	default:
+		return AnyType
+	}
+}
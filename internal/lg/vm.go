@@ -0,0 +1,312 @@
+package lg
+
+import (
+	"slices"
+
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// Program is an expression compiled to bytecode, it's run by the stack VM of
+// Evaluator. Like Block, the code is for the location it's compiled for.
+type Program struct {
+	Expr   Expr // Expr is the compiled expression
+	code   []instr
+	consts []Expr   // consts are the constants, functions and cycle details
+	paths  []Path   // paths are the locations and references
+	args   [][]Expr // args are the unevaluated arguments of forms
+	sites  []site
+	stack  int // stack is the max depth of the value stack
+	loc    Path
+}
+
+func (p *Program) String() string { return p.Expr.String() }
+func (p *Program) Type() ExprType { return p.Expr.Type() }
+
+// source returns the expression of expr, which is assembled into a program for
+// the vm backend.
+func source(expr Expr) Expr {
+	if p, ok := expr.(*Program); ok {
+		return p.Expr
+	}
+	return expr
+}
+
+type opcode uint8
+
+const (
+	opConst     opcode = iota // push consts[a]
+	opLoad                    // push the value at paths[a]
+	opLoadFn                  // push the function at paths[a]
+	opEval                    // push consts[a] evaluated by tree walking
+	opFn                      // push the function consts[a] to be applied
+	opFnRef                   // push the function at paths[a], a form is called with args[b] and jumps to c
+	opApply                   // call the function under a arguments, paths[b] is its name
+	opForm                    // call the form consts[a] with args[b], paths[c] is its name
+	opNewObject               // set an object with the keys of consts[b] at paths[a]
+	opNewArray                // set an array of size b at paths[a]
+	opStore                   // pop and store the value at paths[a]
+	opEnd                     // push the value at paths[a], or fail by the cycles in consts[b]
+)
+
+// instr is an instruction, site is the expression it evaluates, for locating
+// errors and checking the size of result.
+type instr struct {
+	op      opcode
+	a, b, c int32
+	site    int32
+}
+
+// site is an evaluated expression, parent is the site that contains it, or -1.
+type site struct {
+	loc    Path
+	expr   Expr
+	parent int32
+}
+
+// CompileVM compiles expr like Compile, then assembles the result into
+// programs for the VM. Function bodies and arguments of forms are assembled
+// into their own programs.
+func CompileVM(expr Expr, functions []Expr) (Expr, error) {
+	c := newCompiler(expr, functions)
+	plan, err := c.compile(expr, Path{}, Path{})
+	if err != nil {
+		return nil, err
+	}
+	return c.assemble(plan, Path{}), nil
+}
+
+type assembler struct {
+	*compiler
+	p     *Program
+	depth int
+}
+
+func (c *compiler) assemble(expr Expr, loc Path) *Program {
+	a := &assembler{compiler: c, p: &Program{Expr: expr, loc: slices.Clone(loc)}}
+	a.emit(expr, slices.Clone(loc), -1)
+	return a.p
+}
+
+func (a *assembler) op(op opcode, x, y, z, site int32) int {
+	a.p.code = append(a.p.code, instr{op: op, a: x, b: y, c: z, site: site})
+	return len(a.p.code) - 1
+}
+
+func (a *assembler) push(n int) {
+	a.depth += n
+	a.p.stack = max(a.p.stack, a.depth)
+}
+
+func (a *assembler) konst(v Expr) int32 {
+	a.p.consts = append(a.p.consts, v)
+	return int32(len(a.p.consts) - 1)
+}
+
+func (a *assembler) path(p Path) int32 {
+	a.p.paths = append(a.p.paths, slices.Clone(p))
+	return int32(len(a.p.paths) - 1)
+}
+
+func (a *assembler) site(loc Path, expr Expr, parent int32) int32 {
+	a.p.sites = append(a.p.sites, site{loc: slices.Clone(loc), expr: expr, parent: parent})
+	return int32(len(a.p.sites) - 1)
+}
+
+func (a *assembler) emit(expr Expr, loc Path, parent int32) {
+	s := a.site(loc, expr, parent)
+	switch expr := expr.(type) {
+	case Null, String, Number, Int, Decimal, Bool:
+		a.op(opConst, a.konst(expr), 0, 0, s)
+	case ValRef:
+		a.op(opLoad, a.path(Path(expr)), 0, 0, s)
+	case FnRef:
+		a.op(opLoadFn, a.path(Path(expr)), 0, 0, s)
+	case TenpenFn:
+		body := a.assemble(expr.Body, Path{})
+		a.op(opConst, a.konst(TenpenFn{Args: expr.Args, Body: body}), 0, 0, s)
+	case Block:
+		if !slices.Equal(expr.loc, loc) {
+			a.op(opEval, a.konst(expr), 0, 0, s)
+			break
+		}
+		here := a.path(loc)
+		switch v := expr.Expr.(type) {
+		case Object:
+			a.op(opNewObject, here, a.konst(v), 0, s)
+		case Array:
+			a.op(opNewArray, here, int32(len(v)), 0, s)
+		}
+		for i, step := range expr.Steps {
+			child := append(loc, step)
+			a.emit(expr.Values[i], child, s)
+			a.op(opStore, a.path(child), 0, 0, s)
+			a.depth--
+		}
+		cycles := int32(-1)
+		if expr.Cycles != "" {
+			cycles = a.konst(String(expr.Cycles))
+		}
+		a.op(opEnd, here, cycles, 0, s)
+	case FnCall:
+		a.emitFnCall(expr, loc, s)
+		return
+	default:
+		a.op(opEval, a.konst(expr), 0, 0, s)
+	}
+	a.push(1)
+}
+
+func (a *assembler) emitFnCall(call FnCall, loc Path, s int32) {
+	name := a.path(Path(call.FnRef))
+	if form, ok := call.Fn.(Form); ok {
+		_, builtin := a.resolve(call.FnRef)
+		args := make([]Expr, 0, len(call.Args))
+		for _, arg := range call.Args {
+			argLoc := loc // a form evaluates its arguments at the location of call
+			if builtin == "let" {
+				argLoc = Path{} // evaluated by sub evaluators
+			}
+			args = append(args, a.assemble(arg, argLoc))
+		}
+		a.p.args = append(a.p.args, args)
+		a.op(opForm, a.konst(form), int32(len(a.p.args)-1), name, s)
+		a.push(1)
+		return
+	}
+	jump := -1
+	if call.Fn != nil {
+		a.op(opFn, a.konst(call.Fn), 0, 0, s)
+	} else {
+		// it may be a form at runtime, which takes the arguments unevaluated
+		a.p.args = append(a.p.args, call.Args)
+		jump = a.op(opFnRef, name, int32(len(a.p.args)-1), 0, s)
+	}
+	a.push(1)
+	for i, arg := range call.Args {
+		a.emit(arg, append(loc, NumberStep(i)), s)
+	}
+	a.op(opApply, int32(len(call.Args)), name, 0, s)
+	a.depth -= len(call.Args)
+	if jump >= 0 {
+		a.p.code[jump].c = int32(len(a.p.code))
+	}
+}
+
+// run runs the program p, at the location it's compiled for.
+func (e *Evaluator) run(p *Program) (Expr, error) {
+	// the value stack of a small program, like the body of a function, is
+	// not allocated
+	var buf [16]Expr
+	stack := buf[:0]
+	if p.stack > len(buf) {
+		stack = make([]Expr, 0, p.stack)
+	}
+	// the calls are made by e at their locations, since they return before
+	// the next one; the location of e is restored when the program ends, so
+	// a form evaluating its arguments by e keeps its location.
+	defer func(loc Path) { e.loc = loc }(e.loc)
+	at := func(s int32) *Evaluator {
+		e.loc = p.sites[s].loc
+		return e
+	}
+	for pc := 0; pc < len(p.code); pc++ {
+		in := p.code[pc]
+		var v Expr
+		var err error
+		switch in.op {
+		case opConst:
+			if err = e.enter(); err == nil {
+				v = p.consts[in.a]
+			}
+		case opLoad:
+			if err = e.enter(); err == nil {
+				v, err = e.getVal(p.paths[in.a])
+			}
+			if err == nil {
+				stack = append(stack, v)
+				continue // facts are not counted
+			}
+		case opLoadFn:
+			if err = e.enter(); err == nil {
+				v, err = e.getFn(p.paths[in.a])
+			}
+		case opEval:
+			if v, err = e.eval(p.consts[in.a], p.sites[in.site].loc); err == nil {
+				stack = append(stack, v)
+				continue // checked by eval
+			}
+		case opFn:
+			if err = e.enter(); err == nil {
+				stack = append(stack, p.consts[in.a])
+				continue
+			}
+		case opFnRef:
+			var fn Fn
+			if err = e.enter(); err == nil {
+				fn, err = e.getFn(p.paths[in.a])
+			}
+			if err != nil {
+				break
+			}
+			form, ok := fn.(Form)
+			if !ok {
+				stack = append(stack, fn)
+				continue
+			}
+			v, err = form(at(in.site), p.args[in.b])
+			err = inFn(err, FnRef(p.paths[in.a]))
+			pc = int(in.c) - 1
+		case opApply:
+			n := len(stack) - int(in.a)
+			args := slices.Clone(stack[n:])
+			fn := stack[n-1].(Fn)
+			stack = stack[:n-1]
+			if err = checkCallArity(fn, len(args)); err == nil {
+				v, err = fn.Apply(at(in.site), args)
+			}
+			err = inFn(err, FnRef(p.paths[in.b]))
+		case opForm:
+			if err = e.enter(); err == nil {
+				v, err = p.consts[in.a].(Form)(at(in.site), p.args[in.b])
+				err = inFn(err, FnRef(p.paths[in.c]))
+			}
+		case opNewObject:
+			if err = e.enter(); err == nil {
+				err = e.setVal(p.paths[in.a], resultObject(p.consts[in.b].(Object)))
+			}
+			if err == nil {
+				continue
+			}
+		case opNewArray:
+			if err = e.enter(); err == nil {
+				err = e.setVal(p.paths[in.a], make(Array, in.b))
+			}
+			if err == nil {
+				continue
+			}
+		case opStore:
+			err = e.store(p.paths[in.a], stack[len(stack)-1])
+			stack = stack[:len(stack)-1]
+			if err == nil {
+				continue
+			}
+		case opEnd:
+			if in.b >= 0 {
+				err = tperr.CircularRefError().WithDetail("%s", string(p.consts[in.b].(String)))
+			} else {
+				v, err = e.getVal(p.paths[in.a])
+			}
+		}
+		if err == nil {
+			err = e.meter.checkSize(v)
+		}
+		if err != nil {
+			for s := in.site; s >= 0; s = p.sites[s].parent {
+				err = locate(err, p.sites[s].loc, p.sites[s].expr.String())
+			}
+			return nil, err
+		}
+		stack = append(stack, v)
+	}
+	return stack[0], nil
+}
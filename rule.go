@@ -1,9 +1,17 @@
 package tenpen
 
-import "github.com/nanozuki/tenpen/internal/lg"
+import (
+	"context"
+	"encoding/json"
 
+	"github.com/nanozuki/tenpen/internal/lg"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// Rule is a parsed rule, it's immutable and safe for concurrent evaluation.
 type Rule struct {
-	expr   lg.Expr
+	expr   lg.Expr // expr is the parsed rule
+	plan   lg.Expr // plan is the compiled rule for evaluation
 	engine *Engine
 }
 
@@ -11,23 +19,220 @@ func NewRule(rule string) (*Rule, error) {
 	return defaultEngine.NewRule(rule)
 }
 
+// Eval evaluates rule with the environments by the default engine. Both rule
+// and environments are json, a reference is looked up in the rule first, then
+// in the environments from the last to the first.
+func Eval(rule string, envs ...string) (string, error) {
+	r, err := NewRule(rule)
+	if err != nil {
+		return "", err
+	}
+	return r.EvalEnvs(envs...)
+}
+
 func (r *Rule) Eval(facts string) (string, error) {
-	var vals []lg.Expr
+	if facts == "" {
+		return r.EvalEnvs()
+	}
+	return r.EvalEnvs(facts)
+}
+
+// EvalEnvs evaluates the rule with layered environments, the later ones take
+// precedence over the earlier ones.
+func (r *Rule) EvalEnvs(envs ...string) (string, error) {
+	return r.EvalContext(context.Background(), envs...)
+}
+
+// EvalContext is like EvalEnvs, but the evaluation is stopped with a Canceled
+// error once ctx is done.
+func (r *Rule) EvalContext(ctx context.Context, envs ...string) (string, error) {
+	return r.EvalSource(ctx, nil, envs...)
+}
+
+// EvalSource is like EvalContext, and a reference not found in the rule and
+// environments is got from source. The facts got from source are memoized in
+// the evaluation, a nil source is ignored.
+func (r *Rule) EvalSource(ctx context.Context, source FactSource, envs ...string) (string, error) {
+	cfg := r.engine.snapshot()
+	vals, err := cfg.parseEnvs(envs)
+	if err != nil {
+		return "", err
+	}
+	got, err := r.eval(ctx, cfg, r.plan, source, vals)
+	if err != nil {
+		return "", err
+	}
+	return cfg.output(got)
+}
+
+// EvalKeys evaluates only the keys of an object rule and the keys they depend
+// on, and returns an object of the keys in their order. The facts are json
+// like Eval. A key hidden from the output, like a private key or a function,
+// can't be requested.
+func (r *Rule) EvalKeys(facts string, keys ...string) (string, error) {
+	cfg := r.engine.snapshot()
+	var envs []string
 	if facts != "" {
-		val, err := lg.ExprFromBytes([]byte(facts))
+		envs = append(envs, facts)
+	}
+	vals, err := cfg.parseEnvs(envs)
+	if err != nil {
+		return "", err
+	}
+	projected, err := lg.Project(r.expr, keys)
+	if err != nil {
+		return "", err
+	}
+	plan, err := cfg.compile(projected)
+	if err != nil {
+		return "", err
+	}
+	got, err := r.eval(context.Background(), cfg, plan, nil, vals)
+	if err != nil {
+		return "", err
+	}
+	obj := got.(lg.Object)
+	out := lg.NewObject(len(keys))
+	for _, k := range keys {
+		if obj.Hidden(k) {
+			return "", tperr.InvalidArgError().WithDetail("%s is hidden from the output", k)
+		}
+		v, _ := obj.Get(k)
+		out.Set(k, v)
+	}
+	return cfg.output(out)
+}
+
+// EvalValue evaluates the rule with facts of Go values, without json. The facts
+// are converted like encoding/json encodes them, structs by their json tags,
+// and nil means no facts. The result is of the types that encoding/json
+// decodes into an any, like map[string]any, []any and float64, except that
+// integers are int64 and decimals are json.Number.
+func (r *Rule) EvalValue(facts any) (any, error) {
+	got, err := r.evalGo(context.Background(), facts)
+	if err != nil {
+		return nil, err
+	}
+	return lg.ExprToValue(got), nil
+}
+
+// EvalInto evaluates rule with facts like Rule.EvalValue, and decodes the
+// result into T like encoding/json decodes json.
+func EvalInto[T any](rule *Rule, facts any) (T, error) {
+	var out T
+	got, err := rule.evalGo(context.Background(), facts)
+	if err != nil {
+		return out, err
+	}
+	if err := lg.ExprInto(got, &out); err != nil {
+		return out, err
+	}
+	return out, nil
+}
+
+func (r *Rule) evalGo(ctx context.Context, facts any) (lg.Expr, error) {
+	var vals []lg.Expr
+	if facts != nil {
+		val, err := lg.ExprFromGo(facts)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		vals = append(vals, val)
 	}
-	e := lg.NewEvaluator(r.expr, vals, r.engine.funs)
-	gotExpr, err := e.Eval(r.expr)
+	cfg := r.engine.snapshot()
+	got, err := r.eval(ctx, cfg, r.plan, nil, vals)
+	if err != nil {
+		return nil, err
+	}
+	if limits := cfg.limits; limits.MaxOutputBytes > 0 {
+		// the output is limited by its size in json
+		data, err := lg.ExprToBytes(got)
+		if err != nil {
+			return nil, err
+		}
+		if err := limits.CheckOutput(len(data)); err != nil {
+			return nil, err
+		}
+	}
+	return got, nil
+}
+
+// eval evaluates plan of the rule by cfg with environments and source.
+func (r *Rule) eval(ctx context.Context, cfg config, plan lg.Expr, source FactSource, vals []lg.Expr) (lg.Expr, error) {
+	e := cfg.evaluator(ctx, plan, vals)
+	if source != nil {
+		e.WithSource(source)
+	}
+	return e.Eval(plan)
+}
+
+// EvalTrace evaluates the rule with environments like EvalEnvs, and returns
+// the trace of evaluation as a json tree. A step of the tree has the "path" in
+// rule where it's evaluated, the "expr" and its "result" or "error". A value
+// reference has the "source" layer where it's found, like "env 0" for the
+// first environment, "rule" or "scope" for function arguments. A function
+// call has the "fn" called and its evaluated "args". The "steps" are the
+// steps it consists of. The trace is returned with the error of evaluation,
+// to see where it fails. The rule is evaluated by walking the expressions,
+// whatever the backend is.
+func (r *Rule) EvalTrace(envs ...string) (string, error) {
+	return r.EvalTraceContext(context.Background(), envs...)
+}
+
+// EvalTraceContext is like EvalTrace, but the evaluation is stopped with a
+// Canceled error once ctx is done, the trace till then is returned with it.
+func (r *Rule) EvalTraceContext(ctx context.Context, envs ...string) (string, error) {
+	cfg := r.engine.snapshot()
+	vals, err := cfg.parseEnvs(envs)
 	if err != nil {
 		return "", err
 	}
-	got, err := lg.ExprToBytes(gotExpr)
+	plan := r.plan
+	if cfg.backend != BackendTree {
+		if plan, err = lg.Compile(r.expr, cfg.funs); err != nil {
+			return "", err
+		}
+	}
+	e := cfg.evaluator(ctx, plan, vals).WithTrace()
+	_, evalErr := e.Eval(plan)
+	trace, err := json.Marshal(e.Trace())
 	if err != nil {
 		return "", err
 	}
-	return string(got), nil
+	return string(trace), evalErr
+}
+
+// Check statically checks the rule without evaluating it. It reports all the
+// circular references.
+func (r *Rule) Check() error {
+	return lg.CheckCycles(r.expr, r.engine.snapshot().funs)
+}
+
+// TypeCheck infers the types of the rule without evaluating it, and reports
+// the problems of its evaluation: the arguments of wrong types, like adding a
+// string to a number, the calls of wrong arity, and the references to
+// functions that don't exist. schema is the type of facts, like
+// {"price": "number", "tags": ["string"]}, the references missing from it are
+// reported too; the facts are any if it's empty. The inferred type is returned
+// in the same format, the private and function keys are included. See
+// ParseType for the format.
+//
+// The problems that fail the evaluation whenever it gets there are returned
+// as err. The ones that may not are returned as warnings: the problems in a
+// branch that's never taken, like the then of an "$if" of a literal false, and
+// the facts that may be null where null is not expected.
+func (r *Rule) TypeCheck(schema string) (types string, warnings, err error) {
+	cfg := r.engine.snapshot()
+	facts := AnyType
+	if schema != "" {
+		if facts, err = ParseType(schema); err != nil {
+			return "", nil, err
+		}
+	}
+	t, warnings, err := lg.TypeCheck(r.expr, facts, cfg.funs, cfg.sigs)
+	data, merr := t.MarshalJSON()
+	if merr != nil {
+		return "", nil, merr
+	}
+	return string(data), warnings, err
 }
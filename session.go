@@ -0,0 +1,111 @@
+package tenpen
+
+import (
+	"context"
+
+	"github.com/nanozuki/tenpen/internal/lg"
+)
+
+// Session evaluates expressions one by one against facts, like a REPL. The
+// values and functions defined by an object expression are kept for the later
+// expressions, as new layers of the evaluator stacks, so a later definition
+// shadows an earlier one. It's not safe for concurrent use.
+type Session struct {
+	cfg  config
+	vals []lg.Expr // vals are the layers of values, facts and definitions
+	funs []lg.Expr // funs are the layers of functions, the engine's and definitions
+}
+
+// NewSession returns a session with the functions and options of engine, and
+// envs as the first layers of facts.
+func (e *Engine) NewSession(envs ...string) (*Session, error) {
+	cfg := e.snapshot()
+	vals, err := cfg.parseEnvs(envs)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{cfg: cfg, vals: vals, funs: cfg.funs}, nil
+}
+
+// Load adds facts as a layer on the top of the values.
+func (s *Session) Load(facts string) error {
+	val, err := s.cfg.parse(facts)
+	if err != nil {
+		return err
+	}
+	s.vals = append(s.vals, val)
+	return nil
+}
+
+// Eval evaluates expr, which is json like a rule. If it's an object, its keys
+// are defined for the later expressions. The functions in the result are kept,
+// instead of being hidden as in the output of rule.
+func (s *Session) Eval(ctx context.Context, expr string) (Expr, error) {
+	parsed, err := s.cfg.parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := lg.Compile(parsed, s.funs)
+	if err != nil {
+		return nil, err
+	}
+	cfg := s.cfg
+	cfg.funs = s.funs
+	e := cfg.evaluator(ctx, plan, s.vals)
+	got, err := e.Eval(plan)
+	if err != nil {
+		return nil, err
+	}
+	vals, funs := e.Defined()
+	if _, ok := vals.(lg.Object); !ok {
+		return got, nil
+	}
+	s.vals = append(s.vals, vals)
+	if _, ok := funs.(lg.Object); ok {
+		s.funs = append(s.funs, funs)
+	}
+	return withFns(got, funs), nil
+}
+
+// withFns puts the functions in funs back to their places in val.
+func withFns(val lg.Expr, funs lg.Expr) lg.Expr {
+	obj, ok := val.(lg.Object)
+	fnObj, isObj := funs.(lg.Object)
+	if !ok || !isObj {
+		return val
+	}
+	obj = obj.Clone()
+	for k, fn := range fnObj.All() {
+		if _, ok := fn.(lg.Fn); ok {
+			obj.Set(k, fn)
+		} else if v, ok := obj.Get(k); ok {
+			obj.Set(k, withFns(v, fn))
+		}
+	}
+	return obj
+}
+
+// Env returns the values visible to the next expression, the keys of the
+// object layers from the first to the last. A value of later layer takes
+// precedence, and the functions are not included.
+func (s *Session) Env() Object {
+	env := lg.NewObject(0)
+	for _, layer := range s.vals {
+		obj, ok := layer.(lg.Object)
+		if !ok {
+			continue
+		}
+		for k, v := range obj.All() {
+			if _, isNull := v.(lg.Null); isNull && obj.Hidden(k) {
+				continue // the place of a function
+			}
+			env.Set(k, v)
+		}
+	}
+	return env
+}
+
+// Functions returns the sorted names of the functions defined by expressions.
+func (s *Session) Functions() []string {
+	return fnNames(s.funs[len(s.cfg.funs):])
+}
@@ -0,0 +1,124 @@
+package tenpentest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+)
+
+// Diff compares two json texts structurally, and returns the differences of
+// got from want, one per path, like `total: got 10, want 12`. Objects are
+// compared regardless of the order of keys, and numbers by their values, so
+// 1.50 equals 1.5. It returns nil if they are equal.
+func Diff(got, want string) []string {
+	x, err := decode(got)
+	if err != nil {
+		return []string{fmt.Sprintf("got invalid json: %v", err)}
+	}
+	y, err := decode(want)
+	if err != nil {
+		return []string{fmt.Sprintf("want invalid json: %v", err)}
+	}
+	var diffs []string
+	diffValue(&diffs, "", x, y)
+	return diffs
+}
+
+func decode(data string) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// diffValue appends the differences of x from y at path to diffs.
+func diffValue(diffs *[]string, path string, x, y any) {
+	switch x := x.(type) {
+	case []any:
+		if y, ok := y.([]any); ok {
+			if len(x) != len(y) {
+				*diffs = append(*diffs, fmt.Sprintf("%s: got %d elements, want %d", pathName(path), len(x), len(y)))
+			}
+			for i := range min(len(x), len(y)) {
+				diffValue(diffs, join(path, strconv.Itoa(i)), x[i], y[i])
+			}
+			return
+		}
+	case map[string]any:
+		if y, ok := y.(map[string]any); ok {
+			for _, k := range sortedKeys(x) {
+				if yv, ok := y[k]; ok {
+					diffValue(diffs, join(path, k), x[k], yv)
+				} else {
+					*diffs = append(*diffs, fmt.Sprintf("%s: unexpected, got %s", join(path, k), text(x[k])))
+				}
+			}
+			for _, k := range sortedKeys(y) {
+				if _, ok := x[k]; !ok {
+					*diffs = append(*diffs, fmt.Sprintf("%s: missing, want %s", join(path, k), text(y[k])))
+				}
+			}
+			return
+		}
+	default:
+		if isScalarEqual(x, y) {
+			return
+		}
+	}
+	*diffs = append(*diffs, fmt.Sprintf("%s: got %s, want %s", pathName(path), text(x), text(y)))
+}
+
+func isScalarEqual(x, y any) bool {
+	switch x := x.(type) {
+	case json.Number:
+		y, ok := y.(json.Number)
+		if !ok {
+			return false
+		}
+		a, okA := new(big.Rat).SetString(x.String())
+		b, okB := new(big.Rat).SetString(y.String())
+		return okA && okB && a.Cmp(b) == 0
+	case nil, bool, string:
+		return x == y
+	default:
+		return false
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func join(path, step string) string {
+	if path == "" {
+		return step
+	}
+	return path + "." + step
+}
+
+// pathName returns the name of path in messages, the root is "(root)".
+func pathName(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
+
+func text(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
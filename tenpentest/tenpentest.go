@@ -0,0 +1,232 @@
+// Package tenpentest runs declarative tests of rules, written in json without
+// Go. A suite file, named like "quote.test.json", has a rule and its cases:
+//
+//	{
+//	  "rule": {"total": ["$*", "#price", "#qty"]},
+//	  "cases": [
+//	    {"name": "simple", "facts": [{"price": 2, "qty": 3}], "want": {"total": 6}},
+//	    {"name": "no price", "facts": [{"qty": 3}], "error": "no reference"},
+//	    {"name": "partial", "facts": [{"price": 2, "qty": 1}], "want": {"total": 2}, "paths": ["total"]}
+//	  ]
+//	}
+//
+// The rule can be in another file by "rule-file", relative to the suite file,
+// and "decimal" turns on the decimal mode. The facts of a case are layers, the
+// later ones take precedence. A case expects either the output, or the kind
+// of tperr error, like "no reference". With paths, only the values at these
+// paths of the output are compared.
+package tenpentest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// Ext is the extension of suite files.
+const Ext = ".test.json"
+
+// Suite is a rule and its test cases.
+type Suite struct {
+	Rule     json.RawMessage `json:"rule"`
+	RuleFile string          `json:"rule-file"` // RuleFile is relative to the suite file
+	Decimal  bool            `json:"decimal"`   // Decimal turns on the decimal mode
+	Cases    []Case          `json:"cases"`
+
+	rule    *tenpen.Rule
+	ruleErr error // ruleErr is the error of parsing rule, it's expected by cases
+}
+
+// Case is a test case of a rule.
+type Case struct {
+	Name  string            `json:"name"`
+	Facts []json.RawMessage `json:"facts"` // Facts are layers, the later ones take precedence
+	Want  json.RawMessage   `json:"want"`
+	Error string            `json:"error"` // Error is the kind of tperr error, like "no reference"
+	Paths []string          `json:"paths"` // Paths are compared only, if not empty
+}
+
+// Load reads a suite file, and parses its rule.
+func Load(file string) (*Suite, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var s Suite
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, tperr.InvalidJSONError().WithDetail("suite %s: %v", file, err)
+	}
+	rule := string(s.Rule)
+	if s.RuleFile != "" {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(file), s.RuleFile))
+		if err != nil {
+			return nil, err
+		}
+		rule = string(data)
+	}
+	if rule == "" {
+		return nil, tperr.InvalidArgError().WithDetail("suite %s has no rule", file)
+	}
+	for i := range s.Cases {
+		if s.Cases[i].Name == "" {
+			s.Cases[i].Name = fmt.Sprintf("case %d", i)
+		}
+	}
+	engine := tenpen.NewEngine()
+	if s.Decimal {
+		engine.SetDecimal(&tenpen.DecimalOptions{})
+	}
+	s.rule, s.ruleErr = engine.NewRule(rule)
+	return &s, nil
+}
+
+// Files returns the suite files in dir and its subdirectories, sorted.
+func Files(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, Ext) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// RunCase runs a case of the suite, and returns the failures, it's nil if the
+// case passes.
+func (s *Suite) RunCase(c Case) []string {
+	got, err := s.eval(c)
+	if c.Error != "" {
+		var te *tperr.Error
+		switch {
+		case err == nil:
+			return []string{fmt.Sprintf("got %s, want error %q", got, c.Error)}
+		case !errors.As(err, &te) || string(te.Message) != c.Error:
+			return []string{fmt.Sprintf("got error %q, want error %q", err, c.Error)}
+		}
+		return nil
+	}
+	if err != nil {
+		return []string{fmt.Sprintf("got error %q", err)}
+	}
+	if len(c.Want) == 0 {
+		return []string{`the case has neither "want" nor "error"`}
+	}
+	if len(c.Paths) == 0 {
+		return Diff(got, string(c.Want))
+	}
+	var diffs []string
+	for _, path := range c.Paths {
+		diffs = append(diffs, diffPath(got, string(c.Want), path)...)
+	}
+	return diffs
+}
+
+func (s *Suite) eval(c Case) (string, error) {
+	if s.ruleErr != nil {
+		return "", s.ruleErr
+	}
+	envs := make([]string, 0, len(c.Facts))
+	for _, facts := range c.Facts {
+		envs = append(envs, string(facts))
+	}
+	return s.rule.EvalEnvs(envs...)
+}
+
+// diffPath compares the values at path of got and want.
+func diffPath(got, want, path string) []string {
+	p, err := tenpen.ParsePath(path)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %v", path, err)}
+	}
+	wantVal, err := at(want, p)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: not in want", path)}
+	}
+	gotVal, err := at(got, p)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: missing, want %s", path, wantVal)}
+	}
+	var diffs []string
+	for _, d := range Diff(gotVal, wantVal) {
+		if strings.HasPrefix(d, "(root)") {
+			diffs = append(diffs, path+strings.TrimPrefix(d, "(root)"))
+		} else {
+			diffs = append(diffs, path+"."+d)
+		}
+	}
+	return diffs
+}
+
+// at returns the json text at path of data.
+func at(data string, path tenpen.Path) (string, error) {
+	v, err := decode(data)
+	if err != nil {
+		return "", err
+	}
+	for _, step := range path {
+		switch x := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = x[step.String()]; !ok {
+				return "", errNotFound
+			}
+		case []any:
+			i, ok := step.(tenpen.NumberStep)
+			if !ok || int(i) >= len(x) {
+				return "", errNotFound
+			}
+			v = x[i]
+		default:
+			return "", errNotFound
+		}
+	}
+	return text(v), nil
+}
+
+var errNotFound = errors.New("not found")
+
+// Run runs the suite files in dir and its subdirectories as subtests of t, a
+// subtest for a file, and a subtest of it for a case.
+func Run(t *testing.T, dir string) {
+	t.Helper()
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no %s files in %s", Ext, dir)
+	}
+	for _, file := range files {
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			name = file
+		}
+		t.Run(strings.TrimSuffix(name, Ext), func(t *testing.T) {
+			s, err := Load(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range s.Cases {
+				t.Run(c.Name, func(t *testing.T) {
+					for _, failure := range s.RunCase(c) {
+						t.Error(failure)
+					}
+				})
+			}
+		})
+	}
+}
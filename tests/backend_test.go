@@ -0,0 +1,193 @@
+package lg_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// newBackendEngine returns an engine of backend, with the functions used by
+// the corpus.
+func newBackendEngine(backend tenpen.Backend, limits tenpen.Limits) *tenpen.Engine {
+	engine := tenpen.NewEngine()
+	engine.SetBackend(backend)
+	engine.SetLimits(limits)
+	engine.AddFunction("twice", func(e tenpen.Evaller, args []tenpen.Expr) (tenpen.Expr, error) {
+		if len(args) != 2 {
+			return nil, tperr.InvalidArgError().WithDetail("expects 2 arguments, got %d", len(args))
+		}
+		fn, ok := args[0].(tenpen.Fn)
+		if !ok {
+			return nil, tperr.InvalidTypeError().WithDetail("expects function, got %s", args[0].Type())
+		}
+		v, err := fn.Apply(e, args[1:])
+		if err != nil {
+			return nil, err
+		}
+		return fn.Apply(e, []tenpen.Expr{v})
+	})
+	engine.AddModule("str", map[string]tenpen.GoFn{
+		"shout": func(e tenpen.Evaller, args []tenpen.Expr) (tenpen.Expr, error) {
+			s, ok := tenpen.AsString(args[0])
+			if !ok {
+				return nil, tperr.InvalidTypeError().WithDetail("expects string, got %s", args[0].Type())
+			}
+			return tenpen.String(strings.ToUpper(s) + "!"), nil
+		},
+	})
+	return engine
+}
+
+// evalBackend evaluates rule by engine, and formats the result or error for
+// comparing.
+func evalBackend(engine *tenpen.Engine, rule string, envs ...string) string {
+	r, err := engine.NewRule(rule)
+	if err == nil {
+		var got string
+		if got, err = r.EvalEnvs(envs...); err == nil {
+			return got
+		}
+	}
+	return "error: " + err.Error()
+}
+
+// diffBackends evaluates rule by both backends, and reports the difference.
+func diffBackends(t *testing.T, limits tenpen.Limits, rule string, envs ...string) {
+	t.Helper()
+	tree := evalBackend(newBackendEngine(tenpen.BackendTree, limits), rule, envs...)
+	vm := evalBackend(newBackendEngine(tenpen.BackendVM, limits), rule, envs...)
+	if tree != vm {
+		t.Errorf("backends differ\ntree: %s\nvm:   %s", tree, vm)
+	}
+}
+
+var backendCorpus = []struct {
+	name   string
+	rule   string
+	envs   []string
+	limits tenpen.Limits
+}{
+	{name: "scalar", rule: `"hello"`},
+	{name: "arithmetic", rule: `["$+", "#a", ["$*", "#b", 2]]`, envs: []string{`{"a": 1, "b": 2}`}},
+	{name: "object order", rule: `{"c": ["$+", "#b", 1], "b": ["$*", "#a", 2], "a": 1}`},
+	{name: "array references", rule: `[1, ["$+", "#0", 1], ["$*", "#1", 2], {"x": "#2"}]`},
+	{name: "nested", rule: `{"a": {"b": 1, "c": [2, {"d": "#x"}]}, "e": ["$+", "#a.b", "#a.c.0"]}`, envs: []string{`{"x": 3}`}},
+	{name: "layered environments", rule: `{"a": 100, "sum": ["$+", "#a", "#b", "#c"]}`, envs: []string{`{"a": 1, "b": 2, "c": 3}`, `{"b": 20}`}},
+	{name: "defined function", rule: `{"double": ["$def", ["x"], ["$*", "#x", 2]], "r": ["$double", ["$+", "#a", 1]]}`, envs: []string{`{"a": 2}`}},
+	{name: "recursion", rule: `{"fact": ["$def", ["n"], ["$if", ["$<=", "#n", 1], 1, ["$*", "#n", ["$fact", ["$-", "#n", 1]]]]], "r": ["$fact", 10]}`},
+	{name: "closure over rule", rule: `{"k": 3, "add-k": ["$def", ["x"], ["$+", "#x", "#k"]], "r": ["$map", [1, 2], "$add-k"]}`},
+	{name: "key named after function", rule: `{"upper": ["$upper", "#name"]}`, envs: []string{`{"name": "ada"}`}},
+	{name: "alias of form", rule: `{"when": "$if", "r": ["$when", true, 1, ["$+", "x", 1]]}`},
+	{name: "alias of function", rule: `{"plus": "$+", "r": ["$plus", 1, 2]}`},
+	{name: "error through apply", rule: `{"r": ["$apply", "$substr", ["abc", 1, 2, 3]]}`},
+	{name: "missing function through apply", rule: `{"d": ["$def", ["x"], ["$f", "#x"]], "r": ["$apply", "$d", [1]]}`},
+	{name: "let", rule: `["$let", {"x": 2, "y": ["$*", "#x", 3]}, ["$+", "#x", "#y", "#z"]]`, envs: []string{`{"z": 1}`}},
+	{name: "let with object body", rule: `{"r": ["$let", {"x": 1}, {"a": "#x", "b": ["$+", "#a", 1]}]}`},
+	{name: "cond and do", rule: `[["$cond", false, 1, ["$>", 2, 1], 2, 3], ["$do", 1, ["$+", 1, 1]]]`},
+	{name: "short circuit", rule: `[["$and", false, "#missing"], ["$or", true, ["$+", "x", 1]]]`},
+	{name: "higher order", rule: `{"xs": [1, 2, 3, 4], "r": [["$map", "#xs", ["$def", ["x", "i"], ["$*", "#x", "#i"]]], ["$filter", "#xs", ["$def", ["x"], ["$>", "#x", 2]]], ["$reduce", "#xs", "$+", 0], ["$find", "#xs", ["$def", ["x"], ["$==", "#x", 3]]]]}`},
+	{name: "apply", rule: `["$apply", "$+", [1, 5, 3]]`},
+	{name: "strings", rule: `{"s": " Héllo ", "r": [["$upper", ["$trim", "#s"]], ["$len", "#s"], ["$substr", "#s", 1, 3], ["$split", "a,b", ","]]}`},
+	{name: "objects", rule: `{"o": {"a": 1, "b": {"c": 2}}, "r": [["$keys", "#o"], ["$get", "#o", "b.c"], ["$set", "#o", "b.d", 3], ["$pick", "#o", ["a"]]]}`},
+	{name: "host function", rule: `["$twice", ["$def", ["x"], ["$*", "#x", 3]], 2]`},
+	{name: "host module", rule: `["$str.shout", "#s"]`, envs: []string{`{"s": "hey"}`}},
+	{name: "no reference", rule: `{"a": ["$+", 1, "#b.c"]}`},
+	{name: "no function", rule: `{"a": {"b": ["$nothing", 1]}}`},
+	{name: "type error", rule: `{"pricing": {"discount": [1, ["$+", 1, "x"]]}}`},
+	{name: "type error in function", rule: `{"f": ["$def", ["x"], ["$+", "#x", "a"]], "r": ["$map", [1], "$f"]}`},
+	{name: "type error in form", rule: `{"r": ["$if", ["$>", "#a", 1], 1, 2]}`, envs: []string{`{"a": "x"}`}},
+	{name: "host error", rule: `{"r": ["$str.shout", 1]}`},
+	{name: "host arity", rule: `{"r": ["$twice", "$not"]}`},
+	{name: "circular reference", rule: `{"a": ["$+", "#b", 1], "b": ["$+", "#a", 1], "c": 1}`},
+	{name: "circular reference in array", rule: `{"x": ["#x.1", "#x.0", 2]}`},
+	{name: "max steps", rule: `{"fib": ["$def", ["n"], ["$if", ["$<", "#n", 2], "#n", ["$+", ["$fib", ["$-", "#n", 1]], ["$fib", ["$-", "#n", 2]]]]], "r": ["$fib", 15]}`, limits: tenpen.Limits{MaxSteps: 777}},
+	{name: "max depth", rule: `{"down": ["$def", ["n"], ["$if", ["$==", "#n", 0], 0, ["$down", ["$-", "#n", 1]]]], "r": ["$down", 20]}`, limits: tenpen.Limits{MaxDepth: 7}},
+	{name: "max value size", rule: `{"a": [1, 2], "b": {"c": ["$map", "#xs", "$not"]}}`, envs: []string{`{"xs": [true, true, true, true]}`}, limits: tenpen.Limits{MaxValueSize: 3}},
+	{name: "max value size of set", rule: `{"a": ["$set", {}, "a.100000", 1]}`, limits: tenpen.Limits{MaxValueSize: 100}},
+	{name: "max value size of string", rule: `{"a": ["$pad", "x", 1000000], "b": ["$+", "#s", "#s"]}`, envs: []string{`{"s": "abcdef"}`}, limits: tenpen.Limits{MaxValueSize: 10}},
+	{name: "max output bytes", rule: `["$+", "#s", "#s"]`, envs: []string{`{"s": "abcdef"}`}, limits: tenpen.Limits{MaxOutputBytes: 10}},
+}
+
+func TestBackends(t *testing.T) {
+	for _, tt := range backendCorpus {
+		t.Run(tt.name, func(t *testing.T) {
+			diffBackends(t, tt.limits, tt.rule, tt.envs...)
+		})
+	}
+}
+
+func TestBackendVMConcurrent(t *testing.T) {
+	engine := newBackendEngine(tenpen.BackendVM, tenpen.Limits{})
+	rule, err := engine.NewRule(`{"f": ["$def", ["x"], ["$*", "#x", 2]], "r": ["$map", "#xs", "$f"]}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	for i := range 8 {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			for j := range 100 {
+				got, err := rule.EvalContext(context.Background(), fmt.Sprintf(`{"xs": [%d]}`, j))
+				if err != nil {
+					t.Fatalf("EvalContext() error = %v", err)
+				}
+				if want := fmt.Sprintf(`{"r": [%d]}`, j*2); !isJSONEqual(got, want) {
+					t.Fatalf("EvalContext() = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+var benchmarkRules = []struct {
+	name  string
+	rule  string
+	facts string
+}{
+	{
+		name:  "arithmetic",
+		rule:  `["$+", ["$*", "#a", 2], ["$-", "#b", 1], ["$/", "#a", "#b"]]`,
+		facts: `{"a": 6, "b": 3}`,
+	},
+	{
+		name:  "object",
+		rule:  `{"subtotal": ["$*", "#price", "#qty"], "discount": ["$if", ["$>", "#subtotal", 100], ["$*", "#subtotal", 0.1], 0], "tax": ["$*", ["$-", "#subtotal", "#discount"], 0.08], "total": ["$+", ["$-", "#subtotal", "#discount"], "#tax"], "lines": [{"name": "#name", "amount": "#total"}]}`,
+		facts: `{"price": 12.5, "qty": 10, "name": "widget"}`,
+	},
+	{
+		name: "recursion",
+		rule: `{"fib": ["$def", ["n"], ["$if", ["$<", "#n", 2], "#n", ["$+", ["$fib", ["$-", "#n", 1]], ["$fib", ["$-", "#n", 2]]]]], "r": ["$fib", 12]}`,
+	},
+}
+
+func BenchmarkEval(b *testing.B) {
+	backends := []struct {
+		name    string
+		backend tenpen.Backend
+	}{
+		{"tree", tenpen.BackendTree},
+		{"vm", tenpen.BackendVM},
+	}
+	for _, br := range benchmarkRules {
+		for _, be := range backends {
+			b.Run(br.name+"/"+be.name, func(b *testing.B) {
+				engine := tenpen.NewEngine()
+				engine.SetBackend(be.backend)
+				rule, err := engine.NewRule(br.rule)
+				if err != nil {
+					b.Fatalf("NewRule() error = %v", err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for range b.N {
+					if _, err := rule.Eval(br.facts); err != nil {
+						b.Fatalf("Eval() error = %v", err)
+					}
+				}
+			})
+		}
+	}
+}
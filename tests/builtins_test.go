@@ -0,0 +1,210 @@
+package lg_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+type evalCase struct {
+	name    string
+	rule    string
+	facts   string
+	want    string
+	wantErr error
+}
+
+func runEvalCases(t *testing.T, tests []evalCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.facts == "" {
+				diffBackends(t, tenpen.Limits{}, tt.rule)
+			} else {
+				diffBackends(t, tenpen.Limits{}, tt.rule, tt.facts)
+			}
+			rule, err := tenpen.NewRule(tt.rule)
+			if err != nil && tt.wantErr != nil {
+				// some errors are found when the rule is compiled
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("NewRule() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			got, err := rule.Eval(tt.facts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Eval() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if !isJSONEqual(got, tt.want) {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogicBuiltins(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{name: "and", rule: `["$and", true, "#a"]`, facts: `{"a": false}`, want: `false`},
+		{name: "and empty", rule: `["$and", true]`, want: `true`},
+		{name: "and short circuit", rule: `["$and", false, "#missing"]`, want: `false`},
+		{name: "or short circuit", rule: `["$or", true, ["$+", "x", 1]]`, want: `true`},
+		{name: "or", rule: `["$or", false, ["$>", "#a", 1]]`, facts: `{"a": 2}`, want: `true`},
+		{name: "or not bool", rule: `["$or", false, 1]`, wantErr: tperr.InvalidTypeError()},
+		{name: "not", rule: `["$not", ["$==", 1, 2]]`, want: `true`},
+		{name: "not arity", rule: `["$not", true, false]`, wantErr: tperr.InvalidArgError()},
+		{name: "equal deep", rule: `["$==", {"a": [1, {"b": null}]}, "#x"]`, facts: `{"x": {"a": [1, {"b": null}]}}`, want: `true`},
+		{name: "equal deep differ", rule: `["$==", [1, 2], [1, 2, 3]]`, want: `false`},
+		{name: "equal different types", rule: `["$==", 1, "1"]`, want: `false`},
+		{name: "not equal", rule: `["$!=", {"a": 1}, {"a": 2}]`, want: `true`},
+		{name: "not all equal", rule: `["$!=", 1, 1, 2]`, want: `true`},
+		{name: "all equal", rule: `["$!=", "a", "a", "a"]`, want: `false`},
+		{name: "not equal arity", rule: `["$!=", 1]`, wantErr: tperr.InvalidArgError()},
+		{name: "greater", rule: `["$>", 3, 2, 1]`, want: `true`},
+		{name: "less chain", rule: `["$<", 1, 3, 2]`, want: `false`},
+		{name: "greater or equal", rule: `["$>=", 2, 2]`, want: `true`},
+		{name: "less or equal strings", rule: `["$<=", "abc", "abd"]`, want: `true`},
+		{name: "compare mixed", rule: `["$<", 1, "2"]`, wantErr: tperr.InvalidTypeError()},
+		{name: "compare bool", rule: `["$<", true, false]`, wantErr: tperr.InvalidTypeError()},
+	})
+}
+
+func TestFlowBuiltins(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{name: "if then", rule: `["$if", ["$>", "#a", 1], "big", "small"]`, facts: `{"a": 2}`, want: `"big"`},
+		{name: "if else", rule: `["$if", ["$>", "#a", 1], "big", "small"]`, facts: `{"a": 0}`, want: `"small"`},
+		{name: "if without else", rule: `["$if", false, 1]`, want: `null`},
+		{name: "if untaken branch", rule: `["$if", true, 1, ["$+", "#missing", "x"]]`, want: `1`},
+		{name: "if not bool", rule: `["$if", 1, 1, 2]`, wantErr: tperr.InvalidTypeError()},
+		{name: "if arity", rule: `["$if", true]`, wantErr: tperr.InvalidArgError()},
+		{name: "cond", rule: `["$cond", ["$<", "#a", 0], "neg", ["$==", "#a", 0], "zero", "pos"]`, facts: `{"a": 0}`, want: `"zero"`},
+		{name: "cond default", rule: `["$cond", ["$<", "#a", 0], "neg", "pos"]`, facts: `{"a": 3}`, want: `"pos"`},
+		{name: "cond no match", rule: `["$cond", false, 1, false, 2]`, want: `null`},
+		{name: "cond lazy", rule: `["$cond", true, 1, "#missing", 2]`, want: `1`},
+		{name: "let", rule: `["$let", {"x": ["$+", "#a", 1], "y": ["$*", "#x", 2]}, ["$+", "#x", "#y"]]`, facts: `{"a": 1}`, want: `6`},
+		{name: "let shadows facts", rule: `["$let", {"a": 10}, "#a"]`, facts: `{"a": 1}`, want: `10`},
+		{name: "let function", rule: `["$let", {"inc": ["$def", ["x"], ["$+", "#x", 1]]}, ["$inc", 1]]`, want: `2`},
+		{name: "def too many arguments", rule: `{"f": ["$def", ["x"], "#x"], "a": ["$f", 1, 2]}`, wantErr: tperr.InvalidArgError()},
+		{name: "def too few arguments", rule: `["$let", {"f": ["$def", ["x", "y"], "#x"]}, ["$f", 1]]`, wantErr: tperr.InvalidArgError()},
+		{name: "let not object", rule: `["$let", [1], 1]`, wantErr: tperr.InvalidTypeError()},
+		{name: "do", rule: `["$do", 1, ["$+", 1, 1]]`, want: `2`},
+		{name: "apply builtin", rule: `["$apply", "$+", [1, 2, 3]]`, want: `6`},
+		{name: "apply def", rule: `["$apply", ["$def", ["x", "y"], ["$-", "#x", "#y"]], "#args"]`, facts: `{"args": [5, 2]}`, want: `3`},
+		{name: "apply not array", rule: `["$apply", "$+", 1]`, wantErr: tperr.InvalidTypeError()},
+		{
+			name: "recursive function",
+			rule: `{
+				"fact": ["$def", ["n"], ["$if", ["$<=", "#n", 1], 1, ["$*", "#n", ["$fact", ["$-", "#n", 1]]]]],
+				"result": ["$fact", 5]
+			}`,
+			want: `{"result": 120}`,
+		},
+	})
+}
+
+func TestArrayBuiltins(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{
+			name:  "map function ref",
+			rule:  `{"double": ["$def", ["x"], ["$*", "#x", 2]], "double_sum": ["$map", "#input_array", "$double"]}`,
+			facts: `{"input_array": [1, 2, 3]}`,
+			want:  `{"double_sum": [2, 4, 6]}`,
+		},
+		{name: "map inline", rule: `["$map", [1, 2], ["$def", ["x", "i"], ["$+", "#x", "#i"]]]`, want: `[1, 3]`},
+		{name: "map builtin", rule: `["$map", [true, false], "$not"]`, want: `[false, true]`},
+		{name: "map empty", rule: `["$map", [], "$not"]`, want: `[]`},
+		{name: "map not array", rule: `["$map", 1, "$not"]`, wantErr: tperr.InvalidTypeError()},
+		{name: "map not function", rule: `["$map", [1], 1]`, wantErr: tperr.InvalidTypeError()},
+		{name: "map unknown function", rule: `["$map", [1], "$nothing"]`, wantErr: tperr.NoRefError()},
+		{name: "filter", rule: `["$filter", "#a", ["$def", ["x"], ["$>", "#x", 1]]]`, facts: `{"a": [0, 1, 2, 3]}`, want: `[2, 3]`},
+		{name: "filter by index", rule: `["$filter", ["a", "b", "c"], ["$def", ["x", "i"], ["$!=", "#i", 1]]]`, want: `["a", "c"]`},
+		{name: "filter not bool", rule: `["$filter", [1], ["$def", ["x"], "#x"]]`, wantErr: tperr.InvalidTypeError()},
+		{name: "reduce", rule: `["$reduce", [1, 2, 3], "$+", 10]`, want: `16`},
+		{name: "reduce without init", rule: `["$reduce", [1, 2, 3], "$*"]`, want: `6`},
+		{name: "reduce def", rule: `["$reduce", ["a", "b"], ["$def", ["acc", "x", "i"], ["$+", "#acc", "#i"]], 0]`, want: `1`},
+		{name: "reduce empty", rule: `["$reduce", [], "$+"]`, want: `null`},
+		{name: "some", rule: `["$some", [1, 5], ["$def", ["x"], ["$>", "#x", 4]]]`, want: `true`},
+		{name: "some empty", rule: `["$some", [], "$not"]`, want: `false`},
+		{name: "every", rule: `["$every", [1, 5], ["$def", ["x"], ["$>", "#x", 4]]]`, want: `false`},
+		{name: "every empty", rule: `["$every", [], "$not"]`, want: `true`},
+		{name: "find", rule: `["$find", [{"id": 1}, {"id": 2}], ["$def", ["x"], ["$==", "#x.id", 2]]]`, want: `{"id": 2}`},
+		{name: "find none", rule: `["$find", [1], ["$def", ["x"], false]]`, want: `null`},
+	})
+}
+
+func TestStringBuiltins(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{name: "concat", rule: `["$+", "#first", " ", "#last"]`, facts: `{"first": "Ada", "last": "Lovelace"}`, want: `"Ada Lovelace"`},
+		{name: "concat mixed", rule: `["$+", "a", 1]`, wantErr: tperr.InvalidTypeError()},
+		{name: "add mixed", rule: `["$+", 1, "a"]`, wantErr: tperr.InvalidTypeError()},
+		{name: "len unicode", rule: `["$len", "héllo世界"]`, want: `7`},
+		{name: "len array", rule: `["$len", [1, 2, 3]]`, want: `3`},
+		{name: "len number", rule: `["$len", 1]`, wantErr: tperr.InvalidTypeError()},
+		{name: "split", rule: `["$split", "a,b,,c", ","]`, want: `["a", "b", "", "c"]`},
+		{name: "join", rule: `["$join", ["a", "b", "c"], "-"]`, want: `"a-b-c"`},
+		{name: "join not strings", rule: `["$join", ["a", 1], "-"]`, wantErr: tperr.InvalidTypeError()},
+		{name: "upper", rule: `["$upper", "ça va"]`, want: `"ÇA VA"`},
+		{name: "lower", rule: `["$lower", "ÀÉÎ"]`, want: `"àéî"`},
+		{name: "trim", rule: `["$trim", "  a b \n"]`, want: `"a b"`},
+		{name: "trim cutset", rule: `["$trim", "--a-b--", "-"]`, want: `"a-b"`},
+		{name: "substr", rule: `["$substr", "日本語テキスト", 2, 3]`, want: `"語テキ"`},
+		{name: "substr rest", rule: `["$substr", "日本語", 1]`, want: `"本語"`},
+		{name: "substr negative", rule: `["$substr", "abcdef", -2]`, want: `"ef"`},
+		{name: "substr clamp", rule: `["$substr", "abc", 2, 10]`, want: `"c"`},
+		{name: "substr max length", rule: `["$substr", "abc", 1, 9223372036854775807]`, want: `"bc"`},
+		{name: "substr not integer", rule: `["$substr", "abc", 1.5]`, wantErr: tperr.InvalidArgError()},
+		{name: "starts with", rule: `["$starts-with", "#code", "EU-"]`, facts: `{"code": "EU-123"}`, want: `true`},
+		{name: "ends with", rule: `["$ends-with", "file.json", ".yaml"]`, want: `false`},
+		{name: "contains", rule: `["$contains", "hello world", "o w"]`, want: `true`},
+		{name: "replace", rule: `["$replace", "a-b-c", "-", "_"]`, want: `"a_b_c"`},
+		{name: "pad left", rule: `["$pad", "7", 3, "0"]`, want: `"007"`},
+		{name: "pad right", rule: `["$pad", "ab", -4]`, want: `"ab  "`},
+		{name: "pad unicode", rule: `["$pad", "日本", 3, "・"]`, want: `"・日本"`},
+		{name: "pad longer", rule: `["$pad", "abcd", 2]`, want: `"abcd"`},
+		{name: "pad too wide", rule: `["$pad", "x", 1000000000000]`, wantErr: tperr.InvalidArgError()},
+		{name: "pad min width", rule: `["$pad", "x", -9223372036854775808]`, wantErr: tperr.InvalidArgError()},
+		{name: "pad fill", rule: `["$pad", "a", 3, "xy"]`, wantErr: tperr.InvalidArgError()},
+	})
+}
+
+func TestObjectBuiltins(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{name: "len", rule: `["$len", {"a": 1, "b": 2}]`, want: `2`},
+		{name: "keys sorted", rule: `["$keys", "#o"]`, facts: `{"o": {"c": 1, "a": 2, "b": 3}}`, want: `["a", "b", "c"]`},
+		{name: "values", rule: `["$values", {"c": 1, "a": 2, "b": 3}]`, want: `[2, 3, 1]`},
+		{name: "keys not object", rule: `["$keys", [1]]`, wantErr: tperr.InvalidTypeError()},
+		{name: "get path", rule: `["$get", "#o", "a.1.b"]`, facts: `{"o": {"a": [0, {"b": "x"}]}}`, want: `"x"`},
+		{name: "get index", rule: `["$get", [1, 2], 1]`, want: `2`},
+		{name: "get missing", rule: `["$get", {"a": 1}, "b"]`, want: `null`},
+		{name: "get default", rule: `["$get", {"a": 1}, "b.c", 0]`, want: `0`},
+		{name: "merge", rule: `["$merge", {"a": 1, "b": {"x": 1}}, {"b": {"y": 2}, "c": 3}]`, want: `{"a": 1, "b": {"y": 2}, "c": 3}`},
+		{name: "deep merge", rule: `["$deep-merge", {"a": 1, "b": {"x": 1}}, {"b": {"y": 2}, "c": 3}]`, want: `{"a": 1, "b": {"x": 1, "y": 2}, "c": 3}`},
+		{name: "deep merge replace", rule: `["$deep-merge", {"b": {"x": 1}}, {"b": 2}]`, want: `{"b": 2}`},
+		{name: "merge not object", rule: `["$merge", {}, 1]`, wantErr: tperr.InvalidTypeError()},
+		{name: "pick", rule: `["$pick", {"a": 1, "b": 2, "c": 3}, ["a", "c", "d"]]`, want: `{"a": 1, "c": 3}`},
+		{name: "omit", rule: `["$omit", {"a": 1, "b": 2, "c": 3}, ["a", "c"]]`, want: `{"b": 2}`},
+		{name: "entries", rule: `["$entries", {"b": 2, "a": 1}]`, want: `[["a", 1], ["b", 2]]`},
+		{name: "from entries", rule: `["$from-entries", [["a", 1], ["b", [2]]]]`, want: `{"a": 1, "b": [2]}`},
+		{name: "from entries invalid", rule: `["$from-entries", [["a"]]]`, wantErr: tperr.InvalidArgError()},
+		{name: "set", rule: `["$set", {"a": {"b": 1}}, "a.c", 2]`, want: `{"a": {"b": 1, "c": 2}}`},
+		{name: "set create", rule: `["$set", {}, "a.1.b", true]`, want: `{"a": [null, {"b": true}]}`},
+		{
+			name:  "set is immutable",
+			rule:  `{"new": ["$set", "#old", "a.b", 2], "same": ["$get", "#old", "a.b"]}`,
+			facts: `{"old": {"a": {"b": 1}}}`,
+			want:  `{"new": {"a": {"b": 2}}, "same": 1}`,
+		},
+		{name: "set far index", rule: `["$set", {}, "a.99999999999", 1]`, wantErr: tperr.InvalidArgError()},
+		{name: "set through scalar", rule: `["$set", {"a": 1}, "a.b", 2]`, wantErr: tperr.InvalidTypeError()},
+	})
+}
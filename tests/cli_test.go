@@ -0,0 +1,184 @@
+package lg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nanozuki/tenpen/internal/cli"
+)
+
+// writeFiles writes files into a temporary directory, and returns the
+// directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCLI(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"rule.json":   `{"_rate": 0.5, "total": ["$*", "#_rate", "#price"], "who": "#name"}`,
+		"base.json":   `{"price": 10, "name": "a"}`,
+		"over.json":   `{"price": 20}`,
+		"cycle.json":  `{"a": "#b", "b": "#a", "c": "#c"}`,
+		"bad.json":    `{"a": `,
+		"def.json":    `{"f": ["$def", ["x"], "#x"], "r": ["$f", 2]}`,
+		"fmt.json":    "{\n  \"a\": [\"$+\", 1, 2],\n  \"b\": {}\n}\n",
+		"typed.json":  `{"a": ["$+", 1, "x"], "b": ["$nope", 1]}`,
+		"schema.json": `{"price": "number", "name": "string"}`,
+		"qty.json":    `{"total": ["$*", "#price", "#qty"]}`,
+		"warn.json":   `{"a": ["$if", false, ["$+", 1, "x"], 0]}`,
+	})
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		want     string // want is compared as json, or as text if not json; {dir} is the directory of files
+		wantCode int
+		wantErrs []string // wantErrs are the kinds of errors on stderr
+	}{
+		{name: "eval", args: []string{"eval", "rule.json", "base.json"}, want: `{"total": 5, "who": "a"}`},
+		{name: "stacked facts", args: []string{"eval", "rule.json", "base.json", "over.json"}, want: `{"total": 10, "who": "a"}`},
+		{name: "facts from stdin", args: []string{"eval", "rule.json", "-"}, stdin: `{"price": 4, "name": "b"}`, want: `{"total": 2, "who": "b"}`},
+		{name: "explain", args: []string{"eval", "-explain", "rule.json", "base.json", "over.json"}, want: "(root)\n  _rate = 0.5\n  who = #name = \"a\" ({dir}/base.json)\n  total = $*(0.5, 20) = 10\n    #_rate = 0.5 ({dir}/rule.json)\n    #price = 20 ({dir}/over.json)\n"},
+		{name: "explain function", args: []string{"eval", "-explain", "def.json"}, want: "(root)\n  f = [\"$def\",[\"x\"],\"#x\"]\n  r = $f(2) = 2\n    #x = 2 (scope)\n"},
+		{name: "explain stdin", args: []string{"eval", "-explain", "rule.json", "-"}, stdin: `{"price": 4, "name": "b"}`, want: "(root)\n  _rate = 0.5\n  who = #name = \"b\" (-)\n  total = $*(0.5, 4) = 2\n    #_rate = 0.5 ({dir}/rule.json)\n    #price = 4 (-)\n"},
+		{name: "explain error", args: []string{"eval", "-explain", "rule.json", "over.json"}, want: "(root)\n  _rate = 0.5\n  who = #name ! [who] no reference: #name is not found\n", wantCode: cli.ExitRule, wantErrs: []string{"no reference"}},
+		{name: "explain timeout", args: []string{"eval", "-explain", "-timeout", "1ns", "rule.json", "base.json"}, wantCode: cli.ExitRule, wantErrs: []string{"evaluation canceled"}},
+		{name: "eval error", args: []string{"eval", "rule.json", "over.json"}, wantCode: cli.ExitRule, wantErrs: []string{"no reference"}},
+		{name: "invalid facts", args: []string{"eval", "rule.json", "bad.json"}, wantCode: cli.ExitRule, wantErrs: []string{"invalid json"}},
+		{name: "missing file", args: []string{"eval", "rule.json", "nope.json"}, wantCode: cli.ExitIO, wantErrs: []string{"io"}},
+		{name: "unknown backend", args: []string{"eval", "-backend", "x", "rule.json"}, wantCode: cli.ExitUsage, wantErrs: []string{"usage"}},
+		{name: "check", args: []string{"check", "rule.json", "fmt.json"}},
+		{name: "check errors", args: []string{"check", "cycle.json", "rule.json", "bad.json"}, wantCode: cli.ExitRule, wantErrs: []string{"circular reference", "circular reference", "invalid json"}},
+		{name: "check types", args: []string{"check", "-types", "-schema", "schema.json", "rule.json"}, want: `{"file": "{dir}/rule.json", "types": {"_rate": "number", "total": "number", "who": "string"}}`},
+		{name: "check type errors", args: []string{"check", "typed.json"}, wantCode: cli.ExitRule, wantErrs: []string{"invalid type", "no reference"}},
+		{name: "check warnings", args: []string{"check", "warn.json"}, wantErrs: []string{"invalid type"}},
+		{name: "check missing facts", args: []string{"check", "-schema", "schema.json", "cycle.json", "qty.json"}, wantCode: cli.ExitRule, wantErrs: []string{"circular reference", "circular reference", "no reference"}},
+		{name: "fmt", args: []string{"fmt", "-"}, stdin: `{"b": 1, "a": ["$+", 1.50, "<x>"], "c": [{"d": null}]}`, want: "{\n  \"b\": 1,\n  \"a\": [\"$+\", 1.50, \"<x>\"],\n  \"c\": [\n    {\n      \"d\": null\n    }\n  ]\n}\n"},
+		{name: "fmt list", args: []string{"fmt", "-l", "fmt.json", "rule.json"}, want: "{dir}/rule.json\n", wantCode: cli.ExitRule},
+		{name: "fmt invalid", args: []string{"fmt", "bad.json"}, wantCode: cli.ExitRule, wantErrs: []string{"invalid json"}},
+		{name: "no command", wantCode: cli.ExitUsage},
+		{name: "unknown command", args: []string{"run"}, wantCode: cli.ExitUsage, wantErrs: []string{"usage"}},
+		{name: "no rule", args: []string{"eval"}, wantCode: cli.ExitUsage, wantErrs: []string{"usage"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := make([]string, len(tt.args))
+			for i, arg := range tt.args {
+				if strings.HasSuffix(arg, ".json") {
+					arg = filepath.Join(dir, arg)
+				}
+				args[i] = arg
+			}
+			want := strings.ReplaceAll(tt.want, "{dir}", dir)
+			var stdout, stderr bytes.Buffer
+			code := cli.Run(args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("Run() = %d, want %d, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			if got := stdout.String(); want != "" && got != want && !isJSONEqual(got, want) {
+				t.Errorf("stdout = %q, want %q", got, want)
+			}
+			if tt.wantErrs == nil {
+				return
+			}
+			var kinds []string
+			for _, line := range strings.Split(stderr.String(), "\n") {
+				var e struct {
+					Kind string `json:"kind"`
+				}
+				if json.Unmarshal([]byte(line), &e) == nil {
+					kinds = append(kinds, e.Kind)
+				}
+			}
+			if strings.Join(kinds, ",") != strings.Join(tt.wantErrs, ",") {
+				t.Errorf("error kinds = %v, want %v, stderr: %s", kinds, tt.wantErrs, stderr.String())
+			}
+		})
+	}
+}
+
+func TestCLIFmtWrite(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"rule.json": `{"a":["$+",1,2]}`})
+	var stdout, stderr bytes.Buffer
+	name := filepath.Join(dir, "rule.json")
+	if code := cli.Run([]string{"fmt", "-w", name}, nil, &stdout, &stderr); code != cli.ExitOK {
+		t.Fatalf("Run() = %d, stderr: %s", code, stderr.String())
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"a\": [\"$+\", 1, 2]\n}\n"; string(got) != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+func TestCLIRepl(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"facts.json": `{"a": 1}`, "more.json": `{"b": 2}`})
+	input := strings.Join([]string{
+		`["$+", "#a", 1]`,
+		`{"inc": ["$def", ["x"],`,
+		`  ["$+", "#x", 1]]}`,
+		`:load ` + filepath.Join(dir, "more.json"),
+		`["$inc", "#b"]`,
+		`["$inc",`,
+		`0]`,
+		`:env`,
+		`:funcs`,
+		`:quit`,
+		`"not evaluated"`,
+	}, "\n")
+	var stdout, stderr bytes.Buffer
+	code := cli.Run([]string{"repl", filepath.Join(dir, "facts.json")}, strings.NewReader(input), &stdout, &stderr)
+	if code != cli.ExitOK {
+		t.Fatalf("Run() = %d, stderr: %s", code, stderr.String())
+	}
+	for _, want := range []string{
+		"> int<2>\n",
+		"... {\"inc\":def<[string<x>](call<$+(#x,int<1>)>)>}\n",
+		"> int<3>\n",
+		"... int<1>\n",
+		"> {\"a\":int<1>,\"b\":int<2>}\n",
+		"defined:\n  inc\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("stdout = %s, want %q in it", stdout.String(), want)
+		}
+	}
+	if strings.Contains(stdout.String(), "not evaluated") || stderr.Len() > 0 {
+		t.Errorf("stdout = %s, stderr = %s", stdout.String(), stderr.String())
+	}
+}
+
+func TestCLITest(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := cli.Run([]string{"test", "testdata/suites", "testdata/failing"}, nil, &stdout, &stderr)
+	if code != cli.ExitRule {
+		t.Fatalf("Run() = %d, want %d, stderr: %s", code, cli.ExitRule, stderr.String())
+	}
+	for _, want := range []string{
+		"ok   testdata/suites/quote.test.json (4 cases)\n",
+		"--- FAIL: testdata/failing/quote.test.json: wrong\n    discount: got 0, want 1\n    lines: got 2 elements, want 1\n    tax: missing, want 0\n",
+		"--- FAIL: testdata/failing/quote.test.json: wrong error\n",
+		"    lines.0: got \"A\", want \"B\"\n    total: not in want\n",
+		"FAIL testdata/failing/quote.test.json\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("stdout = %s, want %q in it", stdout.String(), want)
+		}
+	}
+	if strings.Contains(stdout.String(), ": pass\n") {
+		t.Errorf("stdout = %s, the passed case is printed", stdout.String())
+	}
+}
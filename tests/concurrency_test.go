@@ -0,0 +1,78 @@
+package lg_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+)
+
+// Run with -race to detect the data races.
+func TestConcurrentEval(t *testing.T) {
+	engine := tenpen.NewEngine()
+	engine.AddFunction("inc", func(e tenpen.Evaller, args []tenpen.Expr) (tenpen.Expr, error) {
+		n, _ := tenpen.AsNumber(args[0])
+		return tenpen.Number(n + 1), nil
+	})
+	rule, err := engine.NewRule(`{
+		"double": ["$def", ["x"], ["$*", "#x", 2]],
+		"fact": ["$def", ["n"], ["$if", ["$<=", "#n", 1], 1, ["$*", "#n", ["$fact", ["$-", "#n", 1]]]]],
+		"doubled": ["$map", "#xs", "$double"],
+		"sum": ["$reduce", "#doubled", "$+", 0],
+		"f": ["$fact", "#n"],
+		"next": ["$inc", "#n"],
+		"user": ["$let", {"loud": ["$upper", "#name"]}, {"name": "#loud", "count": "#n"}]
+	}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+
+	const workers = 16
+	const rounds = 200
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				n := (w+i)%6 + 1
+				facts := fmt.Sprintf(`{"xs": [1, 2, %d], "n": %d, "name": "w%d"}`, n, n, w)
+				got, err := rule.Eval(facts)
+				if err != nil {
+					errs <- err
+					return
+				}
+				fact := 1
+				for k := 2; k <= n; k++ {
+					fact *= k
+				}
+				want := fmt.Sprintf(`{
+					"doubled": [2, 4, %d], "sum": %d, "f": %d, "next": %d,
+					"user": {"name": "W%d", "count": %d}
+				}`, 2*n, 6+2*n, fact, n+1, w, n)
+				if !isJSONEqual(got, want) {
+					errs <- fmt.Errorf("Eval() = %v, want %v", got, want)
+					return
+				}
+			}
+		}(w)
+	}
+	// the functions and limits can be changed during evaluation
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			engine.AddModule(fmt.Sprintf("mod%d", i%4), map[string]tenpen.GoFn{
+				"id": func(e tenpen.Evaller, args []tenpen.Expr) (tenpen.Expr, error) { return args[0], nil },
+			})
+			engine.SetLimits(tenpen.Limits{MaxDepth: 100})
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,88 @@
+package lg_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+func TestEvalContext(t *testing.T) {
+	engine := tenpen.NewEngine()
+	engine.AddFunction("wait", func(e tenpen.Evaller, args []tenpen.Expr) (tenpen.Expr, error) {
+		<-e.Context().Done()
+		return nil, tperr.CanceledError(e.Context().Err())
+	})
+	tests := []struct {
+		name      string
+		rule      string
+		ctx       func() (context.Context, context.CancelFunc)
+		wantCause error
+	}{
+		{
+			name: "deadline in runaway recursion",
+			rule: `{
+				"f": ["$def", ["n"], ["$if", ["$<", "#n", 1], 0, ["$+", ["$f", ["$-", "#n", 1]], ["$f", ["$-", "#n", 1]]]]],
+				"r": ["$f", 60]
+			}`,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+			wantCause: context.DeadlineExceeded,
+		},
+		{
+			name: "deadline in map",
+			rule: `["$map", "#big", ["$def", ["x"], ["$map", "#big", ["$def", ["y"], ["$*", "#x", "#y"]]]]]`,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+			wantCause: context.DeadlineExceeded,
+		},
+		{
+			name: "canceled before evaluation",
+			rule: `["$+", 1, 2]`,
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			wantCause: context.Canceled,
+		},
+		{
+			name: "host function honors context",
+			rule: `{"a": ["$wait", 1]}`,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+			wantCause: context.DeadlineExceeded,
+		},
+	}
+	big := make(tenpen.Array, 0, 100000)
+	for i := 0; i < 100000; i++ {
+		big = append(big, tenpen.Number(i))
+	}
+	facts, err := tenpen.ToJSON(tenpen.ObjectOf(map[string]tenpen.Expr{"big": big}))
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := engine.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			_, err = rule.EvalContext(ctx, string(facts))
+			if !errors.Is(err, tperr.CanceledError(nil)) {
+				t.Fatalf("EvalContext() error = %v, want canceled", err)
+			}
+			if !errors.Is(err, tt.wantCause) {
+				t.Errorf("EvalContext() error = %v, want cause %v", err, tt.wantCause)
+			}
+		})
+	}
+}
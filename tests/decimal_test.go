@@ -0,0 +1,131 @@
+package lg_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *tenpen.DecimalOptions
+		rule    string
+		facts   string
+		want    string // want is compared as text, for the scale
+		wantErr error
+	}{
+		{name: "float mode", rule: `["$+", 0.1, 0.2]`, want: `0.30000000000000004`},
+		{name: "exact add", opts: &tenpen.DecimalOptions{}, rule: `["$+", 0.1, 0.2]`, want: `0.3`},
+		{name: "keep scale", opts: &tenpen.DecimalOptions{}, rule: `["$+", "#price", 0.10]`, facts: `{"price": 12.40}`, want: `12.50`},
+		{name: "multiply scale", opts: &tenpen.DecimalOptions{}, rule: `["$*", "#price", "#qty"]`, facts: `{"price": 1.25, "qty": 3}`, want: `3.75`},
+		{name: "subtract", opts: &tenpen.DecimalOptions{}, rule: `["$-", 1.00, 0.01, 0.99]`, want: `0.00`},
+		{name: "exact quotient", opts: &tenpen.DecimalOptions{}, rule: `["$/", 10, 4]`, want: `2.5`},
+		{name: "exact quotient keeps scale", opts: &tenpen.DecimalOptions{}, rule: `["$/", 12.50, 5]`, want: `2.50`},
+		{name: "inexact quotient", opts: &tenpen.DecimalOptions{}, rule: `["$/", 1, 3]`, want: `0.3333333333333333`},
+		{name: "quotient scale", opts: &tenpen.DecimalOptions{DivScale: 2, Rounding: tenpen.RoundHalfUp}, rule: `["$/", 2, 3]`, want: `0.67`},
+		{name: "quotient rounding", opts: &tenpen.DecimalOptions{DivScale: 2, Rounding: tenpen.RoundDown}, rule: `["$/", 2, 3]`, want: `0.66`},
+		{name: "division by zero", opts: &tenpen.DecimalOptions{}, rule: `["$/", 1, 0]`, wantErr: tperr.InvalidArgError()},
+		{name: "large integer", opts: &tenpen.DecimalOptions{}, rule: `"#id"`, facts: `{"id": 12345678901234567890}`, want: `12345678901234567890`},
+		{name: "exponent", opts: &tenpen.DecimalOptions{}, rule: `["$+", 1.5e3, 2E-2]`, want: `1500.02`},
+		{name: "equal across scales", opts: &tenpen.DecimalOptions{}, rule: `["$==", 1.50, 1.5, "#n"]`, facts: `{"n": 1.500}`, want: `true`},
+		{name: "compare", opts: &tenpen.DecimalOptions{}, rule: `["$<", 0.1, 0.2, 0.30]`, want: `true`},
+		{name: "mixed with float", opts: &tenpen.DecimalOptions{}, rule: `["$+", ["$len", "abc"], 0.5]`, want: `3.5`},
+		{name: "integer argument", opts: &tenpen.DecimalOptions{}, rule: `["$substr", "hello", 1.0, 3]`, want: `"ell"`},
+		{name: "not integer argument", opts: &tenpen.DecimalOptions{}, rule: `["$substr", "hello", 1.5]`, wantErr: tperr.InvalidArgError()},
+		{name: "round", opts: &tenpen.DecimalOptions{}, rule: `["$round", 2.345, 2]`, want: `2.34`},
+		{name: "round up scale", opts: &tenpen.DecimalOptions{}, rule: `["$round", 12.5, 2]`, want: `12.50`},
+		{name: "round float", rule: `["$round", 2.675, 2]`, want: `2.68`},
+		{name: "round float to integer", rule: `["$round", 2.5]`, want: `2`},
+		{name: "round scale out of range", rule: `["$round", 1.5, 50000000]`, wantErr: tperr.InvalidArgError()},
+		{name: "round negative scale out of range", opts: &tenpen.DecimalOptions{}, rule: `["$round", 1.5, -50000000]`, wantErr: tperr.InvalidArgError()},
+		{name: "nested output", opts: &tenpen.DecimalOptions{}, rule: `{"total": ["$*", "#price", 2], "items": [1.10, 2]}`, facts: `{"price": 6.25}`, want: `{"total":12.50,"items":[1.10,2]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tenpen.NewEngine()
+			engine.SetDecimal(tt.opts)
+			rule, err := engine.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			got, err := rule.Eval(tt.facts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Eval() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRounding(t *testing.T) {
+	values := []string{"2.5", "-2.5", "2.45", "-2.45", "2.41"}
+	tests := []struct {
+		rounding tenpen.Rounding
+		want     []string
+	}{
+		{tenpen.RoundHalfEven, []string{"2", "-2", "2.4", "-2.4", "2.4"}},
+		{tenpen.RoundHalfUp, []string{"3", "-3", "2.5", "-2.5", "2.4"}},
+		{tenpen.RoundHalfDown, []string{"2", "-2", "2.4", "-2.4", "2.4"}},
+		{tenpen.RoundUp, []string{"3", "-3", "2.5", "-2.5", "2.5"}},
+		{tenpen.RoundDown, []string{"2", "-2", "2.4", "-2.4", "2.4"}},
+		{tenpen.RoundCeiling, []string{"3", "-2", "2.5", "-2.4", "2.5"}},
+		{tenpen.RoundFloor, []string{"2", "-3", "2.4", "-2.5", "2.4"}},
+	}
+	for _, tt := range tests {
+		engine := tenpen.NewEngine()
+		engine.SetDecimal(&tenpen.DecimalOptions{Rounding: tt.rounding})
+		for i, v := range values {
+			scale := 0
+			if i >= 2 {
+				scale = 1
+			}
+			d, err := tenpen.ParseDecimal(v)
+			if err != nil {
+				t.Fatalf("ParseDecimal(%q) error = %v", v, err)
+			}
+			rule, err := engine.NewRule(`["$round", "#v", "#scale"]`)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			got, err := rule.EvalValue(map[string]any{"v": d, "scale": scale})
+			if err != nil {
+				t.Fatalf("EvalValue() error = %v", err)
+			}
+			if got, ok := got.(interface{ String() string }); !ok || got.String() != tt.want[i] {
+				t.Errorf("rounding %d of %s = %v, want %v", tt.rounding, v, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestEvalIntoDecimal(t *testing.T) {
+	type Invoice struct {
+		Total tenpen.Decimal `json:"total"`
+		Cents int64          `json:"cents"`
+		Rate  float64        `json:"rate"`
+	}
+	engine := tenpen.NewEngine()
+	engine.SetDecimal(&tenpen.DecimalOptions{})
+	rule, err := engine.NewRule(`{"total": ["$+", 0.1, 0.2, 12.40], "cents": ["$*", "#total", 100], "rate": 0.25}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	got, err := tenpen.EvalInto[Invoice](rule, nil)
+	if err != nil {
+		t.Fatalf("EvalInto() error = %v", err)
+	}
+	if got.Total.Text() != "12.70" || got.Cents != 1270 || got.Rate != 0.25 {
+		t.Errorf("EvalInto() = {%s %d %v}, want {12.70 1270 0.25}", got.Total.Text(), got.Cents, got.Rate)
+	}
+}
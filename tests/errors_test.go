@@ -0,0 +1,250 @@
+package lg_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+func TestErrorLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		facts    string
+		wantMsg  string
+		wantFn   string
+		wantExpr string
+	}{
+		{
+			name:     "type error in nested call",
+			rule:     `{"pricing": {"discount": [0, ["$+", "#base", "#code"]]}}`,
+			facts:    `{"base": 1, "code": "x"}`,
+			wantMsg:  "[pricing.discount.1] invalid type: $+ expects number, got string",
+			wantFn:   "$+",
+			wantExpr: "call<$+(#base,#code)>",
+		},
+		{
+			name:     "no reference",
+			rule:     `{"a": {"b": ["$+", 1, "#x.y"]}}`,
+			wantMsg:  "[a.b.1] no reference: #x.y is not found",
+			wantExpr: "#x.y",
+		},
+		{
+			name:     "unknown function",
+			rule:     `{"a": ["$nothing", 1]}`,
+			wantMsg:  "[a] no reference: $nothing is not found",
+			wantFn:   "$nothing",
+			wantExpr: "call<$nothing(int<1>)>",
+		},
+		{
+			name:     "error through apply",
+			rule:     `{"a": {"b": ["$apply", "$substr", ["abc", 1, 2, 3]]}}`,
+			wantMsg:  "[a.b] invalid argument: $substr expects 2 or 3 arguments, got 4",
+			wantFn:   "$substr",
+			wantExpr: "call<$apply($substr,[string<abc>,int<1>,int<2>,int<3>])>",
+		},
+		{
+			name:     "missing function through apply",
+			rule:     `{"d": ["$def", ["x"], ["$f", "#x"]], "a": ["$apply", "$d", [1]]}`,
+			wantMsg:  "[a] no reference: $f is not found",
+			wantFn:   "$f",
+			wantExpr: "call<$apply($d,[int<1>])>",
+		},
+		{
+			name:     "error in form",
+			rule:     `{"a": ["$if", ["$>", 1, "x"], 1, 2]}`,
+			wantMsg:  "[a] invalid type: $> expects number, got string",
+			wantFn:   "$>",
+			wantExpr: "call<$>(int<1>,string<x>)>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := tenpen.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			_, err = rule.Eval(tt.facts)
+			var te *tperr.Error
+			if !errors.As(err, &te) {
+				t.Fatalf("Eval() error = %v, want *tperr.Error", err)
+			}
+			if te.Error() != tt.wantMsg {
+				t.Errorf("Error() = %q, want %q", te.Error(), tt.wantMsg)
+			}
+			if te.Fn != tt.wantFn {
+				t.Errorf("Fn = %q, want %q", te.Fn, tt.wantFn)
+			}
+			if te.Expr != tt.wantExpr {
+				t.Errorf("Expr = %q, want %q", te.Expr, tt.wantExpr)
+			}
+		})
+	}
+}
+
+func TestParseErrorLocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name:    "invalid json",
+			rule:    `{"a": `,
+			wantErr: tperr.InvalidJSONError(),
+			wantMsg: "invalid json: unexpected end of JSON input",
+		},
+		{
+			name:    "invalid reference",
+			rule:    `{"a": [1, "#b..c"]}`,
+			wantErr: tperr.InvalidRefError(),
+			wantMsg: `[a.1] invalid reference: empty step in "b..c"`,
+		},
+		{
+			name:    "arity of builtin",
+			rule:    `{"a": {"b": ["$if", ["$not", true, false], 1]}}`,
+			wantErr: tperr.InvalidArgError(),
+			wantMsg: "[a.b.0] invalid argument: $not expects 1 arguments, got 2",
+		},
+		{
+			name:    "arity in function body",
+			rule:    `{"f": ["$def", ["x"], ["$len", "#x", 1]]}`,
+			wantErr: tperr.InvalidArgError(),
+			wantMsg: "[f] invalid argument: $len expects 1 arguments, got 2",
+		},
+		{
+			name:    "invalid function definition",
+			rule:    `{"f": ["$def", [1], 1]}`,
+			wantErr: tperr.InvalidFnDefError(),
+			wantMsg: "[f] invalid function definition: argument name should be string, got number",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tenpen.NewRule(tt.rule)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err.Error() != tt.wantMsg {
+				t.Errorf("Error() = %q, want %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestCircularRef(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		wantMsg string
+	}{
+		{
+			name:    "self",
+			rule:    `{"a": ["$+", "#a", 1]}`,
+			wantMsg: "circular reference: a -> a",
+		},
+		{
+			name:    "three keys",
+			rule:    `{"a": "#b", "b": "#c", "c": ["$+", "#a", 1], "d": "#a", "e": 1}`,
+			wantMsg: "circular reference: a -> b -> c -> a",
+		},
+		{
+			name:    "reference to child",
+			rule:    `{"x": {"a": 1, "b": "#x.a"}}`,
+			wantMsg: "circular reference: x -> x",
+		},
+		{
+			name:    "nested object",
+			rule:    `{"x": {"p": {"a": 1}}, "y": {"q": ["$+", "#z", 1]}, "z": "#y.q"}`,
+			wantMsg: "circular reference: y -> z -> y",
+		},
+		{
+			name:    "array",
+			rule:    `[1, "#2", "#1"]`,
+			wantMsg: "circular reference: 1 -> 2 -> 1",
+		},
+		{
+			name:    "two cycles",
+			rule:    `{"a": "#b", "b": "#a", "c": "#d", "d": "#c"}`,
+			wantMsg: "circular reference: a -> b -> a; c -> d -> c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := tenpen.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			_, err = rule.Eval("")
+			if !errors.Is(err, tperr.CircularRefError()) {
+				t.Fatalf("Eval() error = %v, want circular reference", err)
+			}
+			if err.Error() != tt.wantMsg {
+				t.Errorf("Error() = %q, want %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestRuleCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		wantMsg string
+	}{
+		{
+			name: "no cycle",
+			rule: `{"a": 1, "b": ["$+", "#a", "#c"], "f": ["$def", ["n"], ["$f", "#n"]]}`,
+		},
+		{
+			name: "all cycles",
+			rule: `{
+				"a": "#b",
+				"b": "#a",
+				"x": {"p": "#x.q", "q": "#x.p"},
+				"y": ["$+", 1, ["$*", 2, [1, "#y.1.1.2", "#y.1.1.1"]]],
+				"f": ["$def", ["v"], {"s": "#t", "t": "#s"}]
+			}`,
+			wantMsg: "circular reference: a -> b -> a\n" +
+				"circular reference: x -> x\n" +
+				"circular reference: y -> y\n" +
+				"[f] circular reference: s -> t -> s\n" +
+				"[x] circular reference: p -> q -> p\n" +
+				"[y.1.1] circular reference: 1 -> 2 -> 1",
+		},
+		{
+			name:    "in if",
+			rule:    `{"x": ["$if", true, {"p": "#x.q", "q": "#x.p"}, 0]}`,
+			wantMsg: "circular reference: x -> x\n[x.1] circular reference: p -> q -> p",
+		},
+		{
+			name:    "in let",
+			rule:    `{"a": ["$let", {"k": "#j", "j": ["$if", true, "#k", 0]}, "#k"]}`,
+			wantMsg: "[a.0] circular reference: j -> k -> j",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := tenpen.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			err = rule.Check()
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Errorf("Check() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tperr.CircularRefError()) {
+				t.Fatalf("Check() error = %v, want circular reference", err)
+			}
+			if err.Error() != tt.wantMsg {
+				t.Errorf("Check() = %q, want %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
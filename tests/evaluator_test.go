@@ -2,9 +2,11 @@ package lg_test
 
 import (
 	"encoding/json"
+	"errors"
 	"testing"
 
 	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
 )
 
 func TestEvaluator(t *testing.T) {
@@ -29,6 +31,53 @@ func TestEvaluator(t *testing.T) {
 			want:    "3",
 			wantErr: nil,
 		},
+		{
+			name:    "recursive object",
+			rule:    `{"sum": ["$+", "#a", "#b.c"], "double-sum": ["$*", "#sum", 2]}`,
+			facts:   `{"a": 1, "b": {"c": 2}}`,
+			want:    `{"sum": 3, "double-sum": 6}`,
+			wantErr: nil,
+		},
+		{
+			name:    "reference in array",
+			rule:    `[1, ["$+", "#0", 1], ["$*", "#1", 2]]`,
+			facts:   "",
+			want:    `[1, 2, 4]`,
+			wantErr: nil,
+		},
+		{
+			name:    "nested object",
+			rule:    `{"a": {"b": 1, "c": [2, 3]}, "d": ["$+", "#a.b", "#a.c.1"]}`,
+			facts:   "",
+			want:    `{"a": {"b": 1, "c": [2, 3]}, "d": 4}`,
+			wantErr: nil,
+		},
+		{
+			name:    "call defined function",
+			rule:    `{"double": ["$def", ["x"], ["$*", "#x", 2]], "result": ["$double", ["$+", "#a", 1]]}`,
+			facts:   `{"a": 2}`,
+			want:    `{"result": 6}`,
+			wantErr: nil,
+		},
+		{
+			name:    "key named after the function it calls",
+			rule:    `{"upper": ["$upper", "#name"]}`,
+			facts:   `{"name": "ada"}`,
+			want:    `{"upper": "ADA"}`,
+			wantErr: nil,
+		},
+		{
+			name:    "no reference",
+			rule:    `["$+", "#a", "#b"]`,
+			facts:   `{"a": 1}`,
+			wantErr: tperr.NoRefError(),
+		},
+		{
+			name:    "circular reference",
+			rule:    `{"a": ["$+", "#b", 1], "b": ["$+", "#a", 1]}`,
+			facts:   "",
+			wantErr: tperr.CircularRefError(),
+		},
 	}
 
 	for _, tt := range tests {
@@ -40,12 +89,14 @@ func TestEvaluator(t *testing.T) {
 			}
 			t.Logf("rule: %v", rule)
 			got, err := rule.Eval(tt.facts)
-			if err != tt.wantErr {
-				t.Errorf("Evaluator.Eval() error = %v, wantErr %v", err, tt.wantErr)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Evaluator.Eval() error = %v, wantErr %v", err, tt.wantErr)
+				}
 				return
 			}
 			if err != nil {
-				t.Errorf("Marshal() error = %v", err)
+				t.Errorf("Evaluator.Eval() error = %v", err)
 				return
 			}
 			if !isJSONEqual(got, tt.want) {
@@ -55,6 +106,64 @@ func TestEvaluator(t *testing.T) {
 	}
 }
 
+func TestEvalEnvs(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		envs    []string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "no environment",
+			rule: `["$+", 1, 2]`,
+			want: `3`,
+		},
+		{
+			name: "last environment first",
+			rule: `["$+", "#a", "#b"]`,
+			envs: []string{`{"a": 1, "b": 2}`, `{"b": 20}`},
+			want: `21`,
+		},
+		{
+			name: "rule first",
+			rule: `{"a": 100, "sum": ["$+", "#a", "#b"]}`,
+			envs: []string{`{"a": 1, "b": 2}`, `{"a": 10}`},
+			want: `{"a": 100, "sum": 102}`,
+		},
+		{
+			name: "fall through missing path",
+			rule: `["$+", "#x.y", "#x.z"]`,
+			envs: []string{`{"x": {"y": 1, "z": 2}}`, `{"x": {"y": 10}}`},
+			want: `12`,
+		},
+		{
+			name:    "not found in any environment",
+			rule:    `"#c"`,
+			envs:    []string{`{"a": 1}`, `{"b": 2}`},
+			wantErr: tperr.NoRefError(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tenpen.Eval(tt.rule, tt.envs...)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Eval() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Eval() error = %v", err)
+				return
+			}
+			if !isJSONEqual(got, tt.want) {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func isJSONEqual(a, b string) bool {
 	var x, y interface{}
 	if err := json.Unmarshal([]byte(a), &x); err != nil {
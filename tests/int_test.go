@@ -0,0 +1,105 @@
+package lg_test
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+func TestInt(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		facts string
+		want  string // want is compared as text, for the digits
+	}{
+		{name: "pass through", rule: `{"user": "#id"}`, facts: `{"id": 9007199254740993}`, want: `{"user":9007199254740993}`},
+		{name: "negative", rule: `"#id"`, facts: `{"id": -9223372036854775808}`, want: `-9223372036854775808`},
+		{name: "out of int64", rule: `["#id"]`, facts: `{"id": 18446744073709551617}`, want: `[18446744073709551617]`},
+		{name: "float", rule: `{"p": 12.5, "r": "#q"}`, facts: `{"q": 0.1}`, want: `{"p":12.5,"r":0.1}`},
+		{name: "float in shortest form", rule: `{"p": 1.50, "r": "#q"}`, facts: `{"q": [1e2, 0.10]}`, want: `{"p":1.5,"r":[100,0.1]}`},
+		{name: "exact add", rule: `["$+", 9007199254740993, 1]`, want: `9007199254740994`},
+		{name: "exact multiply", rule: `["$*", 3037000499, 3037000499]`, want: `9223372030926249001`},
+		{name: "overflow to decimal", rule: `["$*", 9223372036854775807, 2]`, want: `18446744073709551614`},
+		{name: "overflow add", rule: `["$+", 9223372036854775807, 1]`, want: `9223372036854775808`},
+		{name: "overflow sub", rule: `["$-", -9223372036854775808, 1, 0.5]`, want: `-9223372036854775809.5`},
+		{name: "exact quotient", rule: `["$/", 10, 2]`, want: `5`},
+		{name: "overflow quotient", rule: `["$/", -9223372036854775808, -1]`, want: `9223372036854775808`},
+		{name: "inexact quotient", rule: `["$/", 1, 2]`, want: `0.5`},
+		{name: "mixed with float", rule: `["$-", 2, 0.5]`, want: `1.5`},
+		{name: "equal", rule: `["$==", 9007199254740993, 9007199254740992]`, want: `false`},
+		{name: "equal to float", rule: `["$==", 2, 2.0]`, want: `true`},
+		{name: "compare", rule: `["$<", 1, 1.5, 9007199254740993]`, want: `true`},
+		{name: "length", rule: `["$+", ["$len", "abc"], 1]`, want: `4`},
+		{name: "index", rule: `["$map", ["a", "b"], ["$def", ["x", "i"], "#i"]]`, want: `[0,1]`},
+		{name: "round", rule: `["$round", 1234, -2]`, want: `1200`},
+		{name: "round float", rule: `["$round", 1250.5, -2]`, want: `1300`},
+		{name: "round to scale", rule: `["$round", 7, 2]`, want: `7`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := tenpen.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			got, err := rule.Eval(tt.facts)
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+			if tt.facts == "" {
+				diffBackends(t, tenpen.Limits{}, tt.rule)
+			} else {
+				diffBackends(t, tenpen.Limits{}, tt.rule, tt.facts)
+			}
+		})
+	}
+}
+
+func TestIntFromGo(t *testing.T) {
+	rule, err := tenpen.NewRule(`{"user": "#id", "limit": "#max", "next": ["$+", "#id", 1]}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	facts := map[string]any{"id": int64(9007199254740993), "max": uint64(math.MaxUint64)}
+	got, err := rule.EvalValue(facts)
+	if err != nil {
+		t.Fatalf("EvalValue() error = %v", err)
+	}
+	want := map[string]any{
+		"user":  int64(9007199254740993),
+		"limit": json.Number("18446744073709551615"),
+		"next":  int64(9007199254740994),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EvalValue() = %#v, want %#v", got, want)
+	}
+	type IDs struct {
+		ID   int64  `json:"user"`
+		Max  uint64 `json:"limit"`
+		Next int64  `json:"next"`
+	}
+	ids, err := tenpen.EvalInto[IDs](rule, facts)
+	if err != nil {
+		t.Fatalf("EvalInto() error = %v", err)
+	}
+	if ids != (IDs{ID: 9007199254740993, Max: math.MaxUint64, Next: 9007199254740994}) {
+		t.Errorf("EvalInto() = %+v", ids)
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	runEvalCases(t, []evalCase{
+		{name: "integer", rule: `["$/", 1, 0]`, wantErr: tperr.InvalidArgError()},
+		{name: "float", rule: `["$/", 1.5, 0.0]`, wantErr: tperr.InvalidArgError()},
+		{name: "later divisor", rule: `["$/", 8, 2, "#n"]`, facts: `{"n": 0}`, wantErr: tperr.InvalidArgError()},
+		{name: "zero dividend", rule: `["$/", 0, 2]`, want: `0`},
+		{name: "no arguments", rule: `["$apply", "$/", []]`, wantErr: tperr.InvalidArgError()},
+	})
+}
@@ -0,0 +1,121 @@
+package lg_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+func TestLimits(t *testing.T) {
+	const fib = `["$def", ["n"], ["$if", ["$<", "#n", 2], "#n", ["$+", ["$fib", ["$-", "#n", 1]], ["$fib", ["$-", "#n", 2]]]]]`
+	tests := []struct {
+		name    string
+		limits  tenpen.Limits
+		rule    string
+		facts   string
+		want    string
+		wantMsg string // wantMsg is the detail of error, with location if any
+	}{
+		{
+			name:   "within limits",
+			limits: tenpen.Limits{MaxSteps: 1000, MaxDepth: 10, MaxValueSize: 10, MaxOutputBytes: 100},
+			rule:   `{"fib": ` + fib + `, "r": ["$fib", 5]}`,
+			want:   `{"r": 5}`,
+		},
+		{
+			name:    "steps",
+			limits:  tenpen.Limits{MaxSteps: 1000},
+			rule:    `{"fib": ` + fib + `, "r": ["$fib", 20]}`,
+			wantMsg: "MaxSteps of 1000",
+		},
+		{
+			name:    "depth",
+			limits:  tenpen.Limits{MaxDepth: 10},
+			rule:    `{"down": ["$def", ["n"], ["$if", ["$==", "#n", 0], 0, ["$down", ["$-", "#n", 1]]]], "r": ["$down", 20]}`,
+			wantMsg: "MaxDepth of 10",
+		},
+		{
+			name:    "value size",
+			limits:  tenpen.Limits{MaxValueSize: 3},
+			rule:    `{"a": [1, 2], "b": ["$map", "#xs", "$not"]}`,
+			facts:   `{"xs": [true, true, true, true]}`,
+			wantMsg: "[b] MaxValueSize of 3",
+		},
+		{
+			name:    "set index",
+			limits:  tenpen.Limits{MaxValueSize: 100},
+			rule:    `{"a": ["$set", {}, "a.100000", 1]}`,
+			wantMsg: "[a] MaxValueSize of 100",
+		},
+		{
+			name:    "pad width",
+			limits:  tenpen.Limits{MaxValueSize: 100},
+			rule:    `{"a": ["$pad", "x", 1000000]}`,
+			wantMsg: "[a] MaxValueSize of 100",
+		},
+		{
+			name:    "replace",
+			limits:  tenpen.Limits{MaxValueSize: 100},
+			rule:    `{"a": ["$replace", "#s", "a", "#s"]}`,
+			facts:   `{"s": "aaaaaaaaaaaaaaaaaaaa"}`,
+			wantMsg: "[a] MaxValueSize of 100",
+		},
+		{
+			name:    "string bytes",
+			limits:  tenpen.Limits{MaxValueSize: 10},
+			rule:    `{"a": ["$+", "#s", "#s"]}`,
+			facts:   `{"s": "abcdef"}`,
+			wantMsg: "[a] MaxValueSize of 10",
+		},
+		{
+			name:   "within size",
+			limits: tenpen.Limits{MaxValueSize: 10},
+			rule:   `{"a": ["$set", [], 9, ["$pad", "x", -10]]}`,
+			want:   `{"a": [null, null, null, null, null, null, null, null, null, "x         "]}`,
+		},
+		{
+			name:    "output bytes",
+			limits:  tenpen.Limits{MaxOutputBytes: 10},
+			rule:    `["$+", "#s", "#s"]`,
+			facts:   `{"s": "abcdef"}`,
+			wantMsg: "MaxOutputBytes of 10",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tenpen.NewEngine()
+			engine.SetLimits(tt.limits)
+			rule, err := engine.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			got, err := rule.Eval(tt.facts)
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Fatalf("Eval() error = %v", err)
+				}
+				if !isJSONEqual(got, tt.want) {
+					t.Errorf("Eval() = %v, want %v", got, tt.want)
+				}
+				return
+			}
+			if !errors.Is(err, tperr.LimitExceededError("", 0)) {
+				t.Fatalf("Eval() error = %v, want limit exceeded", err)
+			}
+			var te *tperr.Error
+			if !errors.As(err, &te) {
+				t.Fatalf("Eval() error = %v, want *tperr.Error", err)
+			}
+			msg := te.Detail
+			if strings.HasPrefix(tt.wantMsg, "[") {
+				msg = "[" + te.Location + "] " + te.Detail
+			}
+			if msg != tt.wantMsg {
+				t.Errorf("Eval() error = %q, want %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
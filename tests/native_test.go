@@ -0,0 +1,417 @@
+package lg_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+type Address struct {
+	City string `json:"city"`
+	Zip  string `json:"zip,omitempty"`
+}
+
+type Base struct {
+	ID int64 `json:"id"`
+}
+
+type Customer struct {
+	Base
+	Name     string           `json:"name"`
+	Age      int              `json:"age"`
+	Tags     []string         `json:"tags"`
+	Address  *Address         `json:"address"`
+	Scores   map[string]uint8 `json:"scores"`
+	Since    time.Time        `json:"since"`
+	Secret   string           `json:"-"`
+	Nickname string           `json:"nickname,omitempty"`
+	Extra    map[int]bool     `json:"extra"`
+	Plain    string
+	internal string
+}
+
+func TestEvalValue(t *testing.T) {
+	customer := Customer{
+		Base:     Base{ID: 7},
+		Name:     "Ada",
+		Age:      36,
+		Tags:     []string{"vip", "#not-a-ref"},
+		Address:  &Address{City: "London"},
+		Scores:   map[string]uint8{"math": 99},
+		Since:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Secret:   "hidden",
+		Extra:    map[int]bool{1: true},
+		Plain:    "p",
+		internal: "i",
+	}
+	tests := []struct {
+		name    string
+		rule    string
+		facts   any
+		want    any
+		wantErr error
+	}{
+		{
+			name:  "map",
+			rule:  `["$+", "#a", "#b"]`,
+			facts: map[string]any{"a": 1, "b": 2.5},
+			want:  3.5,
+		},
+		{
+			name: "no facts",
+			rule: `{"a": [1, "x", true, null]}`,
+			want: map[string]any{"a": []any{int64(1), "x", true, nil}},
+		},
+		{
+			name:  "struct by json tags",
+			rule:  `{"key": "#id", "who": ["$upper", "#name"], "city": "#address.city", "math": "#scores.math", "from": "#since", "more": ["$keys", "#extra"], "plain": "#Plain"}`,
+			facts: customer,
+			want: map[string]any{
+				"key": int64(7), "who": "ADA", "city": "London", "math": int64(99),
+				"from": "2020-01-02T03:04:05Z", "more": []any{"1"}, "plain": "p",
+			},
+		},
+		{
+			name:  "strings are not references",
+			rule:  `"#tags.1"`,
+			facts: &customer,
+			want:  "#not-a-ref",
+		},
+		{
+			name:  "omitted empty field",
+			rule:  `["$keys", "#address"]`,
+			facts: customer,
+			want:  []any{"city"},
+		},
+		{
+			name:    "omitted empty field in struct",
+			rule:    `"#nickname"`,
+			facts:   customer,
+			wantErr: tperr.NoRefError(),
+		},
+		{
+			name:    "ignored field",
+			rule:    `"#Secret"`,
+			facts:   customer,
+			wantErr: tperr.NoRefError(),
+		},
+		{
+			name:    "unsupported facts",
+			rule:    `"#a"`,
+			facts:   map[string]any{"a": make(chan int)},
+			wantErr: tperr.InvalidTypeError(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := tenpen.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			got, err := rule.EvalValue(tt.facts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("EvalValue() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EvalValue() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EvalValue() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalInto(t *testing.T) {
+	type Quote struct {
+		Total    float64           `json:"total"`
+		Items    int               `json:"items"`
+		Customer Customer          `json:"customer"`
+		Notes    []string          `json:"notes"`
+		Meta     map[string]any    `json:"meta"`
+		Flags    map[int]bool      `json:"flags"`
+		Where    *Address          `json:"where"`
+		Missing  *Address          `json:"missing"`
+		Fixed    [2]int            `json:"fixed"`
+		Counts   map[string]uint16 `json:"counts"`
+	}
+	rule, err := tenpen.NewRule(`{
+		"total": ["$*", "#price", "#qty"],
+		"items": "#qty",
+		"customer": {"id": 7, "NAME": "Ada", "since": "2020-01-02T03:04:05Z", "unknown": 1},
+		"notes": ["$split", "#note", ","],
+		"meta": {"a": [1, "x"]},
+		"flags": {"3": true},
+		"where": {"city": "Paris"},
+		"missing": null,
+		"fixed": [1],
+		"counts": {"a": 2}
+	}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	got, err := tenpen.EvalInto[Quote](rule, map[string]any{"price": 2.5, "qty": 4, "note": "a,b"})
+	if err != nil {
+		t.Fatalf("EvalInto() error = %v", err)
+	}
+	want := Quote{
+		Total: 10,
+		Items: 4,
+		Customer: Customer{
+			Base:  Base{ID: 7},
+			Name:  "Ada",
+			Since: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		Notes:  []string{"a", "b"},
+		Meta:   map[string]any{"a": []any{int64(1), "x"}},
+		Flags:  map[int]bool{3: true},
+		Where:  &Address{City: "Paris"},
+		Fixed:  [2]int{1, 0},
+		Counts: map[string]uint16{"a": 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EvalInto() = %+v, want %+v", got, want)
+	}
+}
+
+func TestEvalIntoError(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		into    func(*tenpen.Rule) error
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name: "type mismatch",
+			rule: `{"items": [1, "two"]}`,
+			into: func(r *tenpen.Rule) error {
+				_, err := tenpen.EvalInto[struct {
+					Items []int `json:"items"`
+				}](r, nil)
+				return err
+			},
+			wantErr: tperr.InvalidTypeError(),
+			wantMsg: "[items.1] invalid type: expects number, got string",
+		},
+		{
+			name: "not integer",
+			rule: `{"n": 1.5}`,
+			into: func(r *tenpen.Rule) error {
+				_, err := tenpen.EvalInto[map[string]int](r, nil)
+				return err
+			},
+			wantErr: tperr.InvalidTypeError(),
+			wantMsg: "[n] invalid type: 1.5 overflows int",
+		},
+		{
+			name: "overflow",
+			rule: `300`,
+			into: func(r *tenpen.Rule) error {
+				_, err := tenpen.EvalInto[uint8](r, nil)
+				return err
+			},
+			wantErr: tperr.InvalidTypeError(),
+			wantMsg: "invalid type: 300 overflows uint8",
+		},
+		{
+			name: "evaluation error",
+			rule: `["$+", "#a", 1]`,
+			into: func(r *tenpen.Rule) error {
+				_, err := tenpen.EvalInto[float64](r, nil)
+				return err
+			},
+			wantErr: tperr.NoRefError(),
+			wantMsg: "no reference: #a is not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := tenpen.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			err = tt.into(rule)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EvalInto() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("Error() = %q, want %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+type Named struct {
+	Name string
+}
+
+type Other struct {
+	Name string
+}
+
+type TaggedName struct {
+	Label string `json:"Name"`
+}
+
+type Node struct {
+	Value int   `json:"value"`
+	Next  *Node `json:"next,omitempty"`
+}
+
+type Loop struct {
+	*Loop
+	V int
+}
+
+func TestEvalValueLikeJSON(t *testing.T) {
+	n, f := 0, 1.5
+	shared := &Address{City: "Paris"}
+	tests := []struct {
+		name  string
+		facts any
+	}{
+		{
+			name: "omitempty",
+			facts: struct {
+				Since  time.Time `json:"since,omitempty"`
+				Base   Base      `json:"base,omitempty"`
+				Pair   [2]int    `json:"pair,omitempty"`
+				None   [0]int    `json:"none,omitempty"`
+				Ptr    *int      `json:"ptr,omitempty"`
+				Zero   *int      `json:"zero,omitempty"`
+				Any    any       `json:"any,omitempty"`
+				Tags   []string  `json:"tags,omitempty"`
+				Empty  []string  `json:"empty,omitempty"`
+				Scores map[string]int
+			}{Ptr: nil, Zero: &n, Empty: []string{}},
+		},
+		{
+			name: "ambiguous embedded fields",
+			facts: struct {
+				Named
+				Other
+				ID int
+			}{Named{"a"}, Other{"b"}, 1},
+		},
+		{
+			name: "tagged embedded field",
+			facts: struct {
+				Named
+				TaggedName
+			}{Named{"a"}, TaggedName{"b"}},
+		},
+		{
+			name: "shallower field",
+			facts: struct {
+				Named
+				Name string
+			}{Named{"a"}, "b"},
+		},
+		{
+			name: "string option",
+			facts: struct {
+				N    int      `json:",string"`
+				S    string   `json:"s,string"`
+				F    *float64 `json:"f,string"`
+				B    bool     `json:"b,string"`
+				Nil  *int     `json:"nil,string"`
+				Tags []string `json:"tags,string"`
+			}{N: 12, S: `say "hi"`, F: &f, B: true, Tags: []string{"x"}},
+		},
+		{
+			name:  "embedded pointer loop",
+			facts: Loop{V: 1},
+		},
+		{
+			name:  "shared pointer",
+			facts: map[string]*Address{"home": shared, "work": shared},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := tenpen.NewRule(`"#v"`)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			got, err := rule.EvalValue(map[string]any{"v": tt.facts})
+			if err != nil {
+				t.Fatalf("EvalValue() error = %v", err)
+			}
+			gotJSON, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			data, err := json.Marshal(tt.facts)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			// the keys are sorted like the result, which is a map
+			var jv any
+			if err := json.Unmarshal(data, &jv); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			want, err := json.Marshal(jv)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(gotJSON) != string(want) {
+				t.Errorf("EvalValue() = %s, want %s", gotJSON, want)
+			}
+		})
+	}
+}
+
+func TestEvalValueCycle(t *testing.T) {
+	node := &Node{Value: 1}
+	node.Next = &Node{Value: 2, Next: node}
+	m := map[string]any{}
+	m["self"] = m
+	s := []any{nil}
+	s[0] = s
+	rule, err := tenpen.NewRule(`"#v"`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	for _, facts := range []any{node, m, s} {
+		if _, err := rule.EvalValue(map[string]any{"v": facts}); !errors.Is(err, tperr.InvalidArgError()) {
+			t.Errorf("EvalValue(%T) error = %v, want invalid argument", facts, err)
+		}
+	}
+}
+
+func TestEvalIntoString(t *testing.T) {
+	type Quoted struct {
+		N int      `json:",string"`
+		S string   `json:"s,string"`
+		F *float64 `json:"f,string"`
+		B bool     `json:"b,string"`
+	}
+	rule, err := tenpen.NewRule(`{"N": "12", "s": "\"hi\"", "f": "1.5", "b": "true"}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	got, err := tenpen.EvalInto[Quoted](rule, nil)
+	if err != nil {
+		t.Fatalf("EvalInto() error = %v", err)
+	}
+	if got.N != 12 || got.S != "hi" || got.F == nil || *got.F != 1.5 || !got.B {
+		t.Errorf("EvalInto() = %+v", got)
+	}
+	rule, err = tenpen.NewRule(`{"N": "x"}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	if _, err := tenpen.EvalInto[Quoted](rule, nil); !errors.Is(err, tperr.InvalidArgError()) {
+		t.Errorf("EvalInto() error = %v, want invalid argument", err)
+	}
+}
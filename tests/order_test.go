@@ -0,0 +1,84 @@
+package lg_test
+
+import (
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+)
+
+func TestKeyOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		facts  string
+		sorted bool
+		want   string // want is compared as text, for the order
+	}{
+		{name: "rule order", rule: `{"z": "#a", "a": 1, "m": {"y": 2, "b": "#a"}}`, want: `{"z":1,"a":1,"m":{"y":2,"b":1}}`},
+		{name: "sorted", rule: `{"z": "#a", "a": 1, "m": {"y": 2, "b": "#a"}}`, sorted: true, want: `{"a":1,"m":{"b":1,"y":2},"z":1}`},
+		{name: "facts order", rule: `"#obj"`, facts: `{"obj": {"b": 1, "a": {"d": 2, "c": 3}}}`, want: `{"b":1,"a":{"d":2,"c":3}}`},
+		{name: "sorted facts", rule: `"#obj"`, facts: `{"obj": {"b": 1, "a": {"d": 2, "c": 3}}}`, sorted: true, want: `{"a":{"c":3,"d":2},"b":1}`},
+		{name: "duplicate key", rule: `{"b": 1, "a": 2, "b": 3}`, want: `{"b":3,"a":2}`},
+		{name: "merge", rule: `["$merge", {"b": 1, "a": 2}, {"c": 3, "b": 4}]`, want: `{"b":4,"a":2,"c":3}`},
+		{name: "deep merge", rule: `["$deep-merge", {"o": {"y": 1}}, {"o": {"x": 2, "y": 3}}]`, want: `{"o":{"y":3,"x":2}}`},
+		{name: "set", rule: `["$set", {"b": 1}, "a.y", 2]`, want: `{"b":1,"a":{"y":2}}`},
+		{name: "pick", rule: `["$pick", {"b": 1, "a": 2, "c": 3}, ["c", "b"]]`, want: `{"b":1,"c":3}`},
+		{name: "from entries", rule: `["$from-entries", [["z", 1], ["a", 2]]]`, want: `{"z":1,"a":2}`},
+		{name: "in function", rule: `{"z": ["$f", 1], "f": ["$def", ["x"], {"k": "#x", "j": 0}]}`, want: `{"z":{"k":1,"j":0}}`},
+		{name: "in let", rule: `["$let", {"y": "#x", "x": 1}, {"b": "#y", "a": "#x"}]`, want: `{"b":1,"a":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, backend := range []tenpen.Backend{tenpen.BackendTree, tenpen.BackendVM} {
+				engine := tenpen.NewEngine()
+				engine.SetBackend(backend)
+				engine.SetSortedKeys(tt.sorted)
+				rule, err := engine.NewRule(tt.rule)
+				if err != nil {
+					t.Fatalf("NewRule() error = %v", err)
+				}
+				got, err := rule.Eval(tt.facts)
+				if err != nil {
+					t.Fatalf("Eval() error = %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("backend %d: Eval() = %v, want %v", backend, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestObject(t *testing.T) {
+	obj := tenpen.NewObject(0)
+	obj.Set("b", tenpen.Int(1))
+	obj.Set("a", tenpen.Int(2))
+	obj.Set("b", tenpen.Int(3))
+	obj.Set("c", tenpen.Int(4))
+	obj.Delete("a")
+	if obj.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", obj.Len())
+	}
+	if v, ok := obj.Get("a"); ok {
+		t.Errorf("Get(a) = %v, want not found", v)
+	}
+	data, err := tenpen.ToJSON(obj)
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+	if string(data) != `{"b":3,"c":4}` {
+		t.Errorf("ToJSON() = %s", data)
+	}
+	clone := obj.Clone()
+	clone.Set("a", tenpen.Int(5))
+	if obj.Len() != 2 {
+		t.Errorf("Clone() shares keys with the object")
+	}
+	data, err = tenpen.ToSortedJSON(tenpen.Array{clone})
+	if err != nil {
+		t.Fatalf("ToSortedJSON() error = %v", err)
+	}
+	if string(data) != `[{"a":5,"b":3,"c":4}]` {
+		t.Errorf("ToSortedJSON() = %s", data)
+	}
+}
@@ -0,0 +1,114 @@
+package lg_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+func TestPrivateKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		facts string
+		want  string
+	}{
+		{name: "private key", rule: `{"_rate": 0.1, "total": ["$*", "#_rate", 100]}`, want: `{"total":10}`},
+		{name: "nested", rule: `{"cfg": {"_k": 2, "v": 1}, "r": ["$+", "#cfg._k", 1]}`, want: `{"cfg":{"v":1},"r":3}`},
+		{name: "passed through", rule: `{"cfg": {"_k": 2, "v": 1}, "copy": "#cfg"}`, want: `{"cfg":{"v":1},"copy":{"v":1}}`},
+		{name: "facts are kept", rule: `{"user": "#u"}`, facts: `{"u": {"_id": 7, "n": "a"}}`, want: `{"user":{"_id":7,"n":"a"}}`},
+		{name: "function", rule: `{"f": ["$def", ["x"], "#x"], "r": ["$f", 1]}`, want: `{"r":1}`},
+		{name: "function reference", rule: `{"g": "$upper", "r": ["$g", "a"]}`, want: `{"r":"A"}`},
+		{name: "in function body", rule: `{"f": ["$def", ["x"], {"_t": "#x", "y": ["$*", "#_t", 2]}], "r": ["$f", 2]}`, want: `{"r":{"y":4}}`},
+		{name: "private result", rule: `{"_all": [1, 2], "n": ["$len", "#_all"]}`, want: `{"n":2}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, backend := range []tenpen.Backend{tenpen.BackendTree, tenpen.BackendVM} {
+				engine := tenpen.NewEngine()
+				engine.SetBackend(backend)
+				rule, err := engine.NewRule(tt.rule)
+				if err != nil {
+					t.Fatalf("NewRule() error = %v", err)
+				}
+				got, err := rule.Eval(tt.facts)
+				if err != nil {
+					t.Fatalf("Eval() error = %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("backend %d: Eval() = %v, want %v", backend, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrivateKeysValue(t *testing.T) {
+	rule, err := tenpen.NewRule(`{"_rate": 2, "f": ["$def", [], 1], "total": ["$*", "#_rate", "#n"]}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	got, err := rule.EvalValue(map[string]any{"n": 3})
+	if err != nil {
+		t.Fatalf("EvalValue() error = %v", err)
+	}
+	if want := map[string]any{"total": int64(6)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EvalValue() = %#v, want %#v", got, want)
+	}
+}
+
+func TestEvalKeys(t *testing.T) {
+	const rule = `{
+		"a": ["$+", "#x", 1],
+		"b": ["$*", "#a", "#_k"],
+		"c": "#missing",
+		"_k": 2,
+		"f": ["$def", ["y"], ["$+", "#y", 1]],
+		"g": ["$f", "#a"]
+	}`
+	tests := []struct {
+		name    string
+		rule    string
+		keys    []string
+		want    string
+		wantErr error
+	}{
+		{name: "dependencies", rule: rule, keys: []string{"b"}, want: `{"b":4}`},
+		{name: "requested order", rule: rule, keys: []string{"b", "a"}, want: `{"b":4,"a":2}`},
+		{name: "function dependency", rule: rule, keys: []string{"g"}, want: `{"g":3}`},
+		{name: "no keys", rule: rule, want: `{}`},
+		{name: "error in key", rule: rule, keys: []string{"a", "c"}, wantErr: tperr.NoRefError()},
+		{name: "unknown key", rule: rule, keys: []string{"z"}, wantErr: tperr.NoRefError()},
+		{name: "private key", rule: rule, keys: []string{"_k"}, wantErr: tperr.InvalidArgError()},
+		{name: "function", rule: rule, keys: []string{"f"}, wantErr: tperr.InvalidArgError()},
+		{name: "not object", rule: `[1]`, keys: []string{"a"}, wantErr: tperr.InvalidTypeError()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, backend := range []tenpen.Backend{tenpen.BackendTree, tenpen.BackendVM} {
+				engine := tenpen.NewEngine()
+				engine.SetBackend(backend)
+				r, err := engine.NewRule(tt.rule)
+				if err != nil {
+					t.Fatalf("NewRule() error = %v", err)
+				}
+				got, err := r.EvalKeys(`{"x": 1}`, tt.keys...)
+				if tt.wantErr != nil {
+					if !errors.Is(err, tt.wantErr) {
+						t.Errorf("backend %d: EvalKeys() error = %v, wantErr %v", backend, err, tt.wantErr)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("backend %d: EvalKeys() error = %v", backend, err)
+				}
+				if got != tt.want {
+					t.Errorf("backend %d: EvalKeys() = %v, want %v", backend, got, tt.want)
+				}
+			}
+		})
+	}
+}
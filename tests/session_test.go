@@ -0,0 +1,66 @@
+package lg_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+func TestSession(t *testing.T) {
+	engine := tenpen.NewEngine()
+	engine.AddModule("str", map[string]tenpen.GoFn{
+		"id": func(e tenpen.Evaller, args []tenpen.Expr) (tenpen.Expr, error) { return args[0], nil },
+	})
+	s, err := engine.NewSession(`{"a": 1, "b": 2}`)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	steps := []struct {
+		load    string
+		expr    string
+		want    string // want is compared with Expr.String()
+		wantErr error
+	}{
+		{expr: `["$+", "#a", 1]`, want: `int<2>`},
+		{expr: `{"inc": ["$def", ["x"], ["$+", "#x", "#a"]], "c": ["$inc", "#b"]}`, want: `{"inc":def<[string<x>](call<$+(#x,#a)>)>,"c":int<3>}`},
+		{expr: `["$inc", "#c"]`, want: `int<4>`},
+		{load: `{"a": 10}`, expr: `["$inc", 0]`, want: `int<10>`},
+		{expr: `{"inc": ["$def", ["x"], "#x"]}`, want: `{"inc":def<[string<x>](#x)>}`},
+		{expr: `["$inc", 5]`, want: `int<5>`},
+		{expr: `["$str.id", "#d"]`, wantErr: tperr.NoRefError()},
+		{expr: `["$+", 1`, wantErr: tperr.InvalidJSONError()},
+	}
+	for _, step := range steps {
+		if step.load != "" {
+			if err := s.Load(step.load); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+		}
+		got, err := s.Eval(context.Background(), step.expr)
+		if step.wantErr != nil {
+			if !errors.Is(err, step.wantErr) {
+				t.Errorf("Eval(%s) error = %v, wantErr %v", step.expr, err, step.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Eval(%s) error = %v", step.expr, err)
+		}
+		if got.String() != step.want {
+			t.Errorf("Eval(%s) = %v, want %v", step.expr, got, step.want)
+		}
+	}
+	if got, want := s.Env().String(), `{"a":int<10>,"b":int<2>,"c":int<3>}`; got != want {
+		t.Errorf("Env() = %v, want %v", got, want)
+	}
+	if got := s.Functions(); !slices.Equal(got, []string{"inc"}) {
+		t.Errorf("Functions() = %v", got)
+	}
+	if got := engine.Functions(); !slices.Contains(got, "+") || !slices.Contains(got, "str.id") {
+		t.Errorf("Engine.Functions() = %v", got)
+	}
+}
@@ -0,0 +1,153 @@
+package lg_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// mapSource is an in-memory fact source, it counts the gets of every path.
+type mapSource struct {
+	mu    sync.Mutex
+	facts map[string]tenpen.Expr
+	err   error
+	gets  map[string]int
+}
+
+func newMapSource(facts map[string]tenpen.Expr) *mapSource {
+	return &mapSource{facts: facts, gets: map[string]int{}}
+}
+
+func (s *mapSource) Get(ctx context.Context, path tenpen.Path) (tenpen.Expr, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets[path.String()]++
+	if s.err != nil {
+		return nil, false, s.err
+	}
+	v, ok := s.facts[path.String()]
+	return v, ok, nil
+}
+
+func TestEvalSource(t *testing.T) {
+	errBackend := errors.New("backend is down")
+	tests := []struct {
+		name     string
+		rule     string
+		envs     []string
+		err      error
+		want     string
+		wantErr  error
+		wantMsg  string
+		wantGets map[string]int
+	}{
+		{
+			name:     "only referenced paths",
+			rule:     `{"who": ["$upper", "#customer.name"], "again": ["$lower", "#customer.name"], "vip": "#customer.vip"}`,
+			want:     `{"who": "ADA", "again": "ada", "vip": true}`,
+			wantGets: map[string]int{"customer.name": 1, "customer.vip": 1},
+		},
+		{
+			name:     "environments first",
+			rule:     `["$+", "#customer.name", "#suffix"]`,
+			envs:     []string{`{"customer": {"name": "Bob"}}`},
+			want:     `"Bob!"`,
+			wantGets: map[string]int{"suffix": 1},
+		},
+		{
+			name:     "rule first",
+			rule:     `{"suffix": "?", "r": ["$+", "#customer.name", "#suffix"]}`,
+			want:     `{"suffix": "?", "r": "Ada?"}`,
+			wantGets: map[string]int{"customer.name": 1},
+		},
+		{
+			name:     "memoized in functions",
+			rule:     `{"greet": ["$def", ["x"], ["$+", "#x", "#suffix"]], "r": ["$map", ["a", "b", "c"], "$greet"]}`,
+			want:     `{"r": ["a!", "b!", "c!"]}`,
+			wantGets: map[string]int{"suffix": 1},
+		},
+		{
+			name:     "untaken branch",
+			rule:     `["$if", "#customer.vip", "#discount", "#customer.name"]`,
+			want:     `0.1`,
+			wantGets: map[string]int{"customer.vip": 1, "discount": 1},
+		},
+		{
+			name:     "not found",
+			rule:     `{"r": ["$+", 1, "#missing"]}`,
+			wantErr:  tperr.NoRefError(),
+			wantMsg:  "[r.1] no reference: #missing is not found",
+			wantGets: map[string]int{"missing": 1},
+		},
+		{
+			name:     "source error",
+			rule:     `{"r": ["$+", 1, "#balance"]}`,
+			err:      errBackend,
+			wantErr:  errBackend,
+			wantMsg:  "[r.1] fact source error: backend is down",
+			wantGets: map[string]int{"balance": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newMapSource(map[string]tenpen.Expr{
+				"customer.name": tenpen.String("Ada"),
+				"customer.vip":  tenpen.Bool(true),
+				"suffix":        tenpen.String("!"),
+				"discount":      tenpen.Number(0.1),
+			})
+			source.err = tt.err
+			rule, err := tenpen.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			got, err := rule.EvalSource(context.Background(), source, tt.envs...)
+			if !reflect.DeepEqual(source.gets, tt.wantGets) {
+				t.Errorf("gets = %v, want %v", source.gets, tt.wantGets)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("EvalSource() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err.Error() != tt.wantMsg {
+					t.Errorf("Error() = %q, want %q", err.Error(), tt.wantMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EvalSource() error = %v", err)
+			}
+			if !isJSONEqual(got, tt.want) {
+				t.Errorf("EvalSource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFactSourceFunc(t *testing.T) {
+	type ctxKey struct{}
+	source := tenpen.FactSourceFunc(func(ctx context.Context, path tenpen.Path) (tenpen.Expr, bool, error) {
+		tenant, _ := ctx.Value(ctxKey{}).(string)
+		if path.String() == "tenant" {
+			return tenpen.String(tenant), true, nil
+		}
+		return nil, false, nil
+	})
+	rule, err := tenpen.NewRule(`["$upper", "#tenant"]`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "acme")
+	got, err := rule.EvalSource(ctx, source)
+	if err != nil {
+		t.Fatalf("EvalSource() error = %v", err)
+	}
+	if got != `"ACME"` {
+		t.Errorf("EvalSource() = %v, want %v", got, `"ACME"`)
+	}
+}
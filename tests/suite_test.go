@@ -0,0 +1,35 @@
+package lg_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nanozuki/tenpen/tenpentest"
+)
+
+func TestSuites(t *testing.T) {
+	tenpentest.Run(t, "testdata/suites")
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+		diff []string
+	}{
+		{name: "equal", got: `{"a": [1, 2.50], "b": null}`, want: `{"b": null, "a": [1.0, 2.5]}`},
+		{name: "scalar", got: `{"a": {"b": 1}}`, want: `{"a": {"b": "1"}}`, diff: []string{`a.b: got 1, want "1"`}},
+		{name: "root", got: `1`, want: `[1]`, diff: []string{`(root): got 1, want [1]`}},
+		{name: "keys", got: `{"a": 1, "c": 3}`, want: `{"a": 1, "b": 2}`, diff: []string{`c: unexpected, got 3`, `b: missing, want 2`}},
+		{name: "array", got: `[1, 2, 3]`, want: `[1, 5]`, diff: []string{`(root): got 3 elements, want 2`, `1: got 2, want 5`}},
+		{name: "big integers", got: `9007199254740993`, want: `9007199254740992`, diff: []string{`(root): got 9007199254740993, want 9007199254740992`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tenpentest.Diff(tt.got, tt.want); !slices.Equal(got, tt.diff) {
+				t.Errorf("Diff() = %q, want %q", got, tt.diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package lg_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+type traceStep struct {
+	Path   string            `json:"path"`
+	Expr   json.RawMessage   `json:"expr"`
+	Source string            `json:"source"`
+	Fn     string            `json:"fn"`
+	Args   []json.RawMessage `json:"args"`
+	Result json.RawMessage   `json:"result"`
+	Error  string            `json:"error"`
+	Steps  []traceStep       `json:"steps"`
+}
+
+// findStep finds the first step of expr in the trace, depth first.
+func findStep(s traceStep, expr string) (traceStep, bool) {
+	if isJSONEqual(string(s.Expr), expr) {
+		return s, true
+	}
+	for _, child := range s.Steps {
+		if found, ok := findStep(child, expr); ok {
+			return found, true
+		}
+	}
+	return traceStep{}, false
+}
+
+func TestEvalTrace(t *testing.T) {
+	const rule = `{
+		"_rate": 0.5,
+		"total": ["$*", "#_rate", "#price"],
+		"f": ["$def", ["x"], ["$+", "#x", 1]],
+		"next": ["$f", "#total"],
+		"big": ["$if", ["$>", "#total", 1], "yes", "no"]
+	}`
+	for _, backend := range []tenpen.Backend{tenpen.BackendTree, tenpen.BackendVM} {
+		engine := tenpen.NewEngine()
+		engine.SetBackend(backend)
+		r, err := engine.NewRule(rule)
+		if err != nil {
+			t.Fatalf("NewRule() error = %v", err)
+		}
+		got, err := r.EvalTrace(`{"price": 4, "x": 100}`, `{"price": 8}`)
+		if err != nil {
+			t.Fatalf("EvalTrace() error = %v", err)
+		}
+		var root traceStep
+		if err := json.Unmarshal([]byte(got), &root); err != nil {
+			t.Fatalf("EvalTrace() = %s, not json: %v", got, err)
+		}
+		if !isJSONEqual(string(root.Result), `{"total": 4, "next": 5, "big": "yes"}`) {
+			t.Errorf("root result = %s", root.Result)
+		}
+		steps := []struct {
+			expr   string
+			path   string
+			source string
+			fn     string
+			args   string
+			result string
+		}{
+			{expr: `"#price"`, path: "total.1", source: "env 1", result: `8`},
+			{expr: `"#_rate"`, path: "total.0", source: "rule", result: `0.5`},
+			{expr: `["$*", "#_rate", "#price"]`, path: "total", fn: "$*", args: `[0.5, 8]`, result: `4`},
+			{expr: `["$f", "#total"]`, path: "next", fn: "$f", args: `[4]`, result: `5`},
+			{expr: `"#x"`, path: "0", source: "scope", result: `4`},
+			{expr: `["$if", ["$>", "#total", 1], "yes", "no"]`, path: "big", fn: "$if", result: `"yes"`},
+		}
+		for _, want := range steps {
+			s, ok := findStep(root, want.expr)
+			if !ok {
+				t.Errorf("backend %d: step %s not found in %s", backend, want.expr, got)
+				continue
+			}
+			args, _ := json.Marshal(s.Args)
+			if s.Path != want.path || s.Source != want.source || s.Fn != want.fn || !isJSONEqual(string(s.Result), want.result) ||
+				(want.args != "" && !isJSONEqual(string(args), want.args)) || (want.args == "" && s.Args != nil) {
+				t.Errorf("backend %d: step %s = %+v", backend, want.expr, s)
+			}
+		}
+	}
+}
+
+func TestEvalTraceContext(t *testing.T) {
+	engine := tenpen.NewEngine()
+	engine.AddFunction("wait", func(e tenpen.Evaller, args []tenpen.Expr) (tenpen.Expr, error) {
+		<-e.Context().Done()
+		return nil, tperr.CanceledError(e.Context().Err())
+	})
+	r, err := engine.NewRule(`{"a": 1, "b": ["$wait", "#a"]}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	got, err := r.EvalTraceContext(ctx)
+	if !errors.Is(err, tperr.CanceledError(nil)) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("EvalTraceContext() error = %v, want canceled by deadline", err)
+	}
+	var root traceStep
+	if err := json.Unmarshal([]byte(got), &root); err != nil {
+		t.Fatalf("EvalTraceContext() = %s, not json: %v", got, err)
+	}
+	if s, ok := findStep(root, `["$wait","#a"]`); !ok || s.Error == "" {
+		t.Errorf("step $wait = %+v, found %v", s, ok)
+	}
+}
+
+func TestEvalTraceError(t *testing.T) {
+	r, err := tenpen.NewRule(`{"a": 1, "b": ["$+", "#a", "#c"]}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	got, err := r.EvalTrace()
+	if !errors.Is(err, tperr.NoRefError()) {
+		t.Errorf("EvalTrace() error = %v, want %v", err, tperr.NoRefError())
+	}
+	var root traceStep
+	if err := json.Unmarshal([]byte(got), &root); err != nil {
+		t.Fatalf("EvalTrace() = %s, not json: %v", got, err)
+	}
+	s, ok := findStep(root, `"#c"`)
+	if !ok || s.Error == "" || s.Result != nil {
+		t.Errorf("step #c = %+v, found %v", s, ok)
+	}
+	if root.Error == "" {
+		t.Errorf("root error is empty")
+	}
+}
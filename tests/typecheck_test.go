@@ -0,0 +1,253 @@
+package lg_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+// errorLines returns the messages of the errors joined in err.
+func errorLines(err error) []string {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var lines []string
+		for _, e := range joined.Unwrap() {
+			lines = append(lines, errorLines(e)...)
+		}
+		return lines
+	}
+	return []string{err.Error()}
+}
+
+func TestTypeCheck(t *testing.T) {
+	const schema = `{"price": "number", "qty": "number?", "name": "string", "tags": ["string"], "user": {"age": "number"}}`
+	tests := []struct {
+		name      string
+		rule      string
+		schema    string
+		want      string
+		wantErrs  []string
+		wantWarns []string
+	}{
+		{
+			name:   "facts",
+			rule:   `{"total": ["$*", "#price", 2], "title": ["$upper", "#name"], "tags": ["$join", "#tags", ","], "adult": ["$>=", "#user.age", 18]}`,
+			schema: schema,
+			want:   `{"total":"number","title":"string","tags":"string","adult":"bool"}`,
+		},
+		{
+			name:     "add string to number",
+			rule:     `{"label": ["$+", "#price", " yen"]}`,
+			schema:   schema,
+			want:     `{"label":"number"}`,
+			wantErrs: []string{"[label] invalid type: $+ expects number, got string"},
+		},
+		{
+			name:     "concat",
+			rule:     `{"label": ["$+", "#name", ": ", "#price"]}`,
+			schema:   schema,
+			want:     `{"label":"string"}`,
+			wantErrs: []string{"[label] invalid type: $+ expects string, got number"},
+		},
+		{
+			name:     "keys",
+			rule:     `{"_rate": 0.1, "tax": ["$*", "#subtotal", "#_rate"], "subtotal": ["$*", 2, 3], "msg": ["$lower", "#tax"]}`,
+			want:     `{"_rate":"number","tax":"number","subtotal":"number","msg":"string"}`,
+			wantErrs: []string{"[msg] invalid type: $lower expects string, got number"},
+		},
+		{
+			name:     "missing facts",
+			rule:     `{"a": "#price", "b": "#nope", "c": "#user.name", "d": "#tags.0"}`,
+			schema:   schema,
+			want:     `{"a":"number","b":"any","c":"any","d":"string"}`,
+			wantErrs: []string{"[b] no reference: #nope is not found", "[c] no reference: #user.name is not found"},
+		},
+		{
+			name: "no schema",
+			rule: `{"a": "#nope", "b": ["$+", "#x", 1]}`,
+			want: `{"a":"any","b":"number"}`,
+		},
+		{
+			name:     "unknown function",
+			rule:     `{"a": ["$sum", 1, 2], "b": ["$map", [1], "$nope"]}`,
+			want:     `{"a":"any","b":["any"]}`,
+			wantErrs: []string{"[a] no reference: $sum is not found", "[b.1] no reference: $nope is not found"},
+		},
+		{
+			name:     "function arity",
+			rule:     `{"f": ["$def", ["x", "y"], ["$+", "#x", "#y"]], "a": ["$f", 1], "b": ["$f", 1, 2, 3], "c": ["$f", 1, 2]}`,
+			want:     `{"f":"fn","a":"number","b":"number","c":"number"}`,
+			wantErrs: []string{"[a] invalid argument: $f expects 2 arguments, got 1", "[b] invalid argument: $f expects 2 arguments, got 3"},
+		},
+		{
+			name:     "function arguments",
+			rule:     `{"double": ["$def", ["x"], ["$*", "#x", 2]], "a": ["$double", 2], "b": ["$double", "2"]}`,
+			want:     `{"double":"fn","a":"number","b":"number"}`,
+			wantErrs: []string{"[double] invalid type: $* expects number, got string"},
+		},
+		{
+			name:     "function body",
+			rule:     `{"f": ["$def", ["x"], ["$nope", "#x"]]}`,
+			want:     `{"f":"fn"}`,
+			wantErrs: []string{"[f] no reference: $nope is not found"},
+		},
+		{
+			name: "recursion",
+			rule: `{"fact": ["$def", ["n"], ["$if", ["$<=", "#n", 1], 1, ["$*", "#n", ["$fact", ["$-", "#n", 1]]]]], "a": ["$fact", 5]}`,
+			want: `{"fact":"fn","a":"number"}`,
+		},
+		{
+			name:   "higher-order",
+			rule:   `{"names": ["$map", "#tags", "$upper"], "long": ["$filter", "#tags", ["$def", ["t"], ["$>", ["$len", "#t"], 3]]], "sum": ["$reduce", [1, 2], ["$def", ["a", "b"], ["$+", "#a", "#b"]], 0], "first": ["$find", "#tags", ["$def", ["t", "i"], ["$==", "#i", 0]]]}`,
+			schema: schema,
+			want:   `{"names":["string"],"long":["string"],"sum":"number","first":"string?"}`,
+		},
+		{
+			name:     "predicate",
+			rule:     `{"a": ["$filter", [1, 2], ["$def", ["x"], ["$+", "#x", 1]]], "b": ["$map", "#price", "$upper"]}`,
+			schema:   schema,
+			want:     `{"a":["number"],"b":["string"]}`,
+			wantErrs: []string{"[a] invalid type: $filter expects bool, got number", "[b] invalid type: $map expects array, got number"},
+		},
+		{
+			name: "forms",
+			rule: `{"a": ["$if", "#ok", 1, null], "b": ["$let", {"k": 2, "j": ["$*", "#k", 3]}, ["$+", "#j", 1]], "c": ["$cond", false, "x", true, "y"], "d": ["$and", true, "#ok"]}`,
+			want: `{"a":"number?","b":"number","c":"string?","d":"bool"}`,
+		},
+		{
+			name:     "condition",
+			rule:     `{"a": ["$if", "#price", 1, 2], "b": ["$not", "#name"]}`,
+			schema:   schema,
+			want:     `{"a":"number","b":"bool"}`,
+			wantErrs: []string{"[a] invalid type: $if expects bool, got number", "[b] invalid type: $not expects bool, got string"},
+		},
+		{
+			name:      "nullable",
+			rule:      `{"a": ["$+", "#qty", 1], "b": ["$if", ["$==", "#qty", null], 0, "#qty"]}`,
+			schema:    schema,
+			want:      `{"a":"number","b":"number?"}`,
+			wantWarns: []string{"[a] invalid type: $+ expects number, got number or null"},
+		},
+		{
+			name: "dead branch",
+			rule: `{"a": ["$if", false, ["$+", 1, "a"], 0], "b": ["$cond", true, 1, ["$upper", 1], 2, ["$lower", 2]], "c": ["$or", true, ["$not", 1]], "d": ["$if", true, 1, ["$+", 1, "b"]]}`,
+			want: `{"a":"number","b":"any","c":"bool","d":"number"}`,
+			wantWarns: []string{
+				"[a.1] invalid type: $+ expects number, got string",
+				"[b.2] invalid type: $upper expects string, got number",
+				"[b] invalid type: $cond expects bool, got string",
+				"[b.4] invalid type: $lower expects string, got number",
+				"[c.1] invalid type: $not expects bool, got number",
+				"[d.2] invalid type: $+ expects number, got string",
+			},
+		},
+		{
+			name:     "live branch",
+			rule:     `{"a": ["$if", true, ["$+", 1, "a"], 0], "b": ["$and", true, ["$not", 1]]}`,
+			want:     `{"a":"number","b":"bool"}`,
+			wantErrs: []string{"[a.1] invalid type: $+ expects number, got string", "[b.1] invalid type: $not expects bool, got number"},
+		},
+		{
+			name:     "null",
+			rule:     `{"a": ["$+", null, 1]}`,
+			want:     `{"a":"number"}`,
+			wantErrs: []string{"[a] invalid type: $+ expects number, got null"},
+		},
+		{
+			name: "containers",
+			rule: `{"a": {"b": [1, 2], "c": ["x", null]}, "d": ["$len", "#a.b"], "e": "#a.c.0", "f": ["$get", "#a", "c"]}`,
+			want: `{"a":{"b":["number"],"c":["string?"]},"d":"number","e":"string","f":"any"}`,
+		},
+		{
+			name: "circular",
+			rule: `{"a": ["$+", "#b", 1], "b": ["$+", "#a", 1]}`,
+			want: `{"a":"number","b":"number"}`,
+		},
+		{
+			name:     "nested",
+			rule:     `{"a": ["$upper", ["$*", 2, ["$len", "#name"]]]}`,
+			schema:   schema,
+			want:     `{"a":"string"}`,
+			wantErrs: []string{"[a] invalid type: $upper expects string, got number"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := tenpen.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			got, warnings, err := rule.TypeCheck(tt.schema)
+			if got != tt.want {
+				t.Errorf("TypeCheck() = %v, want %v", got, tt.want)
+			}
+			if lines := errorLines(err); strings.Join(lines, "\n") != strings.Join(tt.wantErrs, "\n") {
+				t.Errorf("TypeCheck() errors = %q, want %q", lines, tt.wantErrs)
+			}
+			if lines := errorLines(warnings); strings.Join(lines, "\n") != strings.Join(tt.wantWarns, "\n") {
+				t.Errorf("TypeCheck() warnings = %q, want %q", lines, tt.wantWarns)
+			}
+		})
+	}
+}
+
+func TestTypedFunction(t *testing.T) {
+	engine := tenpen.NewEngine()
+	noop := func(e tenpen.Evaller, args []tenpen.Expr) (tenpen.Expr, error) { return tenpen.Null{}, nil }
+	engine.AddTypedFunction("tax", noop, tenpen.Signature{
+		Params:   []tenpen.Type{tenpen.NumberType, tenpen.StringType},
+		Optional: 1,
+		Result:   tenpen.NumberType,
+	})
+	tags, err := tenpen.ParseType(`["string"]`)
+	if err != nil {
+		t.Fatalf("ParseType() error = %v", err)
+	}
+	engine.AddTypedFunction("tags", noop, tenpen.Signature{Params: []tenpen.Type{tenpen.StringType}, Result: tags})
+	engine.AddFunction("any", noop)
+	rule, err := engine.NewRule(`{"a": ["$tax", 1], "b": ["$tax", "1"], "c": ["$tax", 1, "jp", 2], "d": ["$tags", "x"], "e": ["$any", 1, "x"]}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	got, _, err := rule.TypeCheck("")
+	if want := `{"a":"number","b":"number","c":"number","d":["string"],"e":"any"}`; got != want {
+		t.Errorf("TypeCheck() = %v, want %v", got, want)
+	}
+	wantErrs := []string{
+		"[b] invalid type: $tax expects number, got string",
+		"[c] invalid argument: $tax expects 1 or 2 arguments, got 3",
+	}
+	if lines := errorLines(err); strings.Join(lines, "\n") != strings.Join(wantErrs, "\n") {
+		t.Errorf("TypeCheck() errors = %q, want %q", lines, wantErrs)
+	}
+
+	// the signature is dropped when the function is replaced
+	engine.AddFunction("tax", noop)
+	rule, err = engine.NewRule(`{"b": ["$tax", "1"]}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	if _, _, err := rule.TypeCheck(""); err != nil {
+		t.Errorf("TypeCheck() error = %v", err)
+	}
+}
+
+func TestTypeCheckSchema(t *testing.T) {
+	rule, err := tenpen.NewRule(`{"a": 1}`)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	for _, schema := range []string{`{"a": "int"}`, `[]`, `{"a": `} {
+		if _, _, err := rule.TypeCheck(schema); err == nil {
+			t.Errorf("TypeCheck(%s) error = nil", schema)
+		}
+	}
+	if _, _, err := rule.TypeCheck(`{"a": "int"}`); !errors.Is(err, tperr.InvalidArgError()) {
+		t.Errorf("TypeCheck() error = %v, want invalid argument", err)
+	}
+}
@@ -0,0 +1,182 @@
+package lg_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nanozuki/tenpen"
+	"github.com/nanozuki/tenpen/tperr"
+)
+
+func TestCustomFunction(t *testing.T) {
+	engine := tenpen.NewEngine()
+	engine.AddFunction("double", func(e tenpen.Evaller, args []tenpen.Expr) (tenpen.Expr, error) {
+		n, ok := tenpen.AsNumber(args[0])
+		if !ok {
+			return nil, tperr.InvalidTypeError()
+		}
+		return tenpen.Number(n * 2), nil
+	})
+	engine.AddModule("str", map[string]tenpen.GoFn{
+		"upper": func(e tenpen.Evaller, args []tenpen.Expr) (tenpen.Expr, error) {
+			s, ok := tenpen.AsString(args[0])
+			if !ok {
+				return nil, tperr.InvalidTypeError()
+			}
+			return tenpen.String(strings.ToUpper(s)), nil
+		},
+	})
+
+	tests := []struct {
+		name    string
+		rule    string
+		facts   string
+		want    string
+		wantErr error
+	}{
+		{
+			name:  "function",
+			rule:  `["$double", "#a"]`,
+			facts: `{"a": 21}`,
+			want:  `42`,
+		},
+		{
+			name:  "module function",
+			rule:  `["$str.upper", "#a"]`,
+			facts: `{"a": "abc"}`,
+			want:  `"ABC"`,
+		},
+		{
+			name:    "host error",
+			rule:    `["$double", "#a"]`,
+			facts:   `{"a": "abc"}`,
+			wantErr: tperr.InvalidTypeError(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := engine.NewRule(tt.rule)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			got, err := rule.Eval(tt.facts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Eval() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if !isJSONEqual(got, tt.want) {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValueConversion(t *testing.T) {
+	expr, err := tenpen.FromJSON([]byte(`{"a": [1, "x", true, null], "b": "#a.0", "c": ["$+", 1, 2]}`))
+	if err != nil {
+		t.Fatalf("FromJSON() error = %v", err)
+	}
+	obj, ok := tenpen.AsObject(expr)
+	if !ok {
+		t.Fatalf("AsObject() not ok, got %v", expr)
+	}
+	a, _ := obj.Get("a")
+	arr, ok := tenpen.AsArray(a)
+	if !ok || len(arr) != 4 {
+		t.Fatalf("AsArray() = %v, %v", arr, ok)
+	}
+	if n, ok := tenpen.AsNumber(arr[0]); !ok || n != 1 {
+		t.Errorf("AsNumber() = %v, %v", n, ok)
+	}
+	if s, ok := tenpen.AsString(arr[1]); !ok || s != "x" {
+		t.Errorf("AsString() = %v, %v", s, ok)
+	}
+	if b, ok := tenpen.AsBool(arr[2]); !ok || !b {
+		t.Errorf("AsBool() = %v, %v", b, ok)
+	}
+	if !tenpen.IsNull(arr[3]) {
+		t.Errorf("IsNull() = false, want true")
+	}
+	ref, err := tenpen.NewValRef("a.0")
+	if err != nil {
+		t.Fatalf("NewValRef() error = %v", err)
+	}
+	b, _ := obj.Get("b")
+	if b.Type() != tenpen.ExprValRef || b.String() != ref.String() {
+		t.Errorf("ref = %v, want %v", b, ref)
+	}
+	call, err := tenpen.NewFnCall("+", tenpen.Int(1), tenpen.Int(2))
+	if err != nil {
+		t.Fatalf("NewFnCall() error = %v", err)
+	}
+	if c, _ := obj.Get("c"); c.String() != call.String() {
+		t.Errorf("call = %v, want %v", c, call)
+	}
+	data, err := tenpen.ToJSON(tenpen.ObjectOf(map[string]tenpen.Expr{"n": tenpen.Number(1), "s": tenpen.String("x")}))
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+	if !isJSONEqual(string(data), `{"n": 1, "s": "x"}`) {
+		t.Errorf("ToJSON() = %s", data)
+	}
+}
+
+func TestFromValueUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"int", 1, "invalid argument: unsupported type int"},
+		{"slice", []string{}, "invalid argument: unsupported type []string"},
+		{"nested", map[string]any{"a": []any{"x", struct{}{}}}, "[a.1] invalid argument: unsupported type struct {}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tenpen.FromValue(tt.v)
+			if !errors.Is(err, tperr.InvalidArgError()) || err.Error() != tt.want {
+				t.Errorf("FromValue() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestZeroObject(t *testing.T) {
+	var obj tenpen.Object
+	if _, ok := obj.Get("a"); ok || obj.Len() != 0 {
+		t.Fatalf("zero Object is not empty")
+	}
+	obj.Set("b", tenpen.Int(2))
+	obj.Set("a", tenpen.Int(1))
+	data, err := tenpen.ToJSON(obj)
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+	if string(data) != `{"b":2,"a":1}` {
+		t.Errorf("ToJSON() = %s, want %s", data, `{"b":2,"a":1}`)
+	}
+}
+
+func TestFunctionJSON(t *testing.T) {
+	const def = `["$def",["x"],["$+","#x",1]]`
+	expr, err := tenpen.FromJSON([]byte(def))
+	if err != nil {
+		t.Fatalf("FromJSON() error = %v", err)
+	}
+	if expr.Type() != tenpen.ExprFn {
+		t.Fatalf("FromJSON() = %v, want a function", expr)
+	}
+	data, err := tenpen.ToJSON(expr)
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+	if string(data) != def {
+		t.Errorf("ToJSON() = %s, want %s", data, def)
+	}
+}
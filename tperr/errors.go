@@ -1,17 +1,37 @@
 package tperr
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type Error struct {
-	Location string
+	Location string // Location is the path in rule where the error occurs
 	Message  ErrorMessages
+	Fn       string // Fn is the function reference, like "$+"
+	Expr     string // Expr is the expression which failed
+	Detail   string
+	Cause    error // Cause is the underlying error, like context.Canceled
 }
 
 func (e *Error) Error() string {
-	if e.Location == "" {
-		return string(e.Message)
+	var b strings.Builder
+	if e.Location != "" {
+		fmt.Fprintf(&b, "[%s] ", e.Location)
+	}
+	b.WriteString(string(e.Message))
+	if e.Fn != "" || e.Detail != "" {
+		b.WriteString(":")
+	}
+	if e.Fn != "" {
+		b.WriteString(" ")
+		b.WriteString(e.Fn)
 	}
-	return fmt.Sprintf("[%s] %s", e.Location, e.Message)
+	if e.Detail != "" {
+		b.WriteString(" ")
+		b.WriteString(e.Detail)
+	}
+	return b.String()
 }
 
 func (e *Error) Is(err error) bool {
@@ -24,6 +44,10 @@ func (e *Error) Is(err error) bool {
 	return false
 }
 
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
 func (e *Error) As(target interface{}) bool {
 	if target == nil {
 		return false
@@ -35,6 +59,12 @@ func (e *Error) As(target interface{}) bool {
 	return false
 }
 
+// WithDetail sets the detail of error, and returns the error itself.
+func (e *Error) WithDetail(format string, args ...any) *Error {
+	e.Detail = fmt.Sprintf(format, args...)
+	return e
+}
+
 type ErrorMessages string
 
 const (
@@ -47,58 +77,95 @@ const (
 	CircularRef   ErrorMessages = "circular reference"
 	InvalidType   ErrorMessages = "invalid type"
 	InvalidArg    ErrorMessages = "invalid argument"
+	Canceled      ErrorMessages = "evaluation canceled"
+	LimitExceeded ErrorMessages = "limit exceeded"
+	FactSource    ErrorMessages = "fact source error"
 )
 
-func InvalidJSONError() *Error { // TODO: add location
+func InvalidJSONError() *Error {
 	return &Error{
 		Message: InvalidJSON,
 	}
 }
 
-func InvalidRefError() *Error { // TODO: add location
+func InvalidRefError() *Error {
 	return &Error{
 		Message: InvalidRef,
 	}
 }
 
-func InvalidFnNameError() *Error { // TODO: add location
+func InvalidFnNameError() *Error {
 	return &Error{
 		Message: InvalidFnName,
 	}
 }
 
-func InvalidFnCallError() *Error { // TODO: add location
+func InvalidFnCallError() *Error {
 	return &Error{
 		Message: InvalidFnCall,
 	}
 }
 
-func InvalidFnDefError() *Error { // TODO: add location
+func InvalidFnDefError() *Error {
 	return &Error{
 		Message: InvalidFnDef,
 	}
 }
 
-func NoRefError() *Error { // TODO: add location
+func NoRefError() *Error {
 	return &Error{
 		Message: NoRef,
 	}
 }
 
-func CircularRefError() *Error { // TODO: add Location
+func CircularRefError() *Error {
 	return &Error{
 		Message: CircularRef,
 	}
 }
 
-func InvalidTypeError() *Error { // TODO: add Location
+func InvalidTypeError() *Error {
 	return &Error{
 		Message: InvalidType,
 	}
 }
 
-func InvalidArgError() *Error { // TODO: add location
+func InvalidArgError() *Error {
 	return &Error{
 		Message: InvalidArg,
 	}
 }
+
+// CanceledError is returned when the context of evaluation is done, cause is
+// the error of context.
+func CanceledError(cause error) *Error {
+	e := &Error{
+		Message: Canceled,
+		Cause:   cause,
+	}
+	if cause != nil {
+		e.Detail = cause.Error()
+	}
+	return e
+}
+
+// LimitExceededError is returned when an evaluation exceeds one of its limits.
+func LimitExceededError(limit string, max int) *Error {
+	return &Error{
+		Message: LimitExceeded,
+		Detail:  fmt.Sprintf("%s of %d", limit, max),
+	}
+}
+
+// FactSourceError is returned when a fact source fails to get a fact, cause is
+// the error of source.
+func FactSourceError(cause error) *Error {
+	e := &Error{
+		Message: FactSource,
+		Cause:   cause,
+	}
+	if cause != nil {
+		e.Detail = cause.Error()
+	}
+	return e
+}
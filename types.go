@@ -0,0 +1,36 @@
+package tenpen
+
+import "github.com/nanozuki/tenpen/internal/lg"
+
+// Type is the static type of values, inferred by Rule.TypeCheck. The zero Type
+// is any, which is compatible with every type.
+type Type = lg.Type
+
+// Signature is the type of a function added by Engine.AddTypedFunction.
+type Signature = lg.Signature
+
+// The types of scalar values, FnType is the type of functions.
+var (
+	AnyType    = lg.AnyType
+	NullType   = lg.NullType
+	BoolType   = lg.BoolType
+	NumberType = lg.NumberType
+	StringType = lg.StringType
+	FnType     = lg.FnType
+)
+
+// ArrayOf returns the type of arrays of elem.
+func ArrayOf(elem Type) Type {
+	return lg.ArrayOf(elem)
+}
+
+// ParseType parses a type in the schema format of Rule.TypeCheck, like
+// "number", "string?" for a string or null, ["string"] for an array of strings
+// and {"price": "number"} for an object.
+func ParseType(schema string) (Type, error) {
+	expr, err := lg.ExprFromBytes([]byte(schema))
+	if err != nil {
+		return AnyType, err
+	}
+	return lg.ParseType(expr)
+}
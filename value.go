@@ -0,0 +1,241 @@
+package tenpen
+
+import (
+	"math"
+
+	"github.com/nanozuki/tenpen/internal/lg"
+)
+
+// Value types of the rule language. They are aliases of the internal types, so
+// a host function can build, inspect and return values directly.
+type (
+	Expr     = lg.Expr
+	ExprType = lg.ExprType
+	Null     = lg.Null
+	String   = lg.String
+	Number   = lg.Number
+	Int      = lg.Int
+	Bool     = lg.Bool
+	Array    = lg.Array
+	Object   = lg.Object
+	ValRef   = lg.ValRef
+	FnRef    = lg.FnRef
+	FnCall   = lg.FnCall
+	Fn       = lg.Fn
+	TenpenFn = lg.TenpenFn
+	GoFn     = lg.GoFn
+	Form     = lg.Form
+	Evaller  = lg.Evaller
+	Decimal  = lg.Decimal
+)
+
+// FactSource provides facts on demand, FactSourceFunc is a function that
+// implements it.
+type (
+	FactSource     = lg.FactSource
+	FactSourceFunc = lg.FactSourceFunc
+)
+
+type (
+	Path       = lg.Path
+	Step       = lg.Step
+	StepType   = lg.StepType
+	StringStep = lg.StringStep
+	NumberStep = lg.NumberStep
+)
+
+const (
+	ExprNull   = lg.ExprNull
+	ExprString = lg.ExprString
+	ExprNumber = lg.ExprNumber
+	ExprBool   = lg.ExprBool
+	ExprArray  = lg.ExprArray
+	ExprObject = lg.ExprObject
+	ExprValRef = lg.ExprValRef
+	ExprFnRef  = lg.ExprFnRef
+	ExprFnCall = lg.ExprFnCall
+	ExprFn     = lg.ExprFn
+)
+
+// Rounding is the mode to round a decimal.
+type Rounding = lg.Rounding
+
+const (
+	RoundHalfEven = lg.RoundHalfEven
+	RoundHalfUp   = lg.RoundHalfUp
+	RoundHalfDown = lg.RoundHalfDown
+	RoundUp       = lg.RoundUp
+	RoundDown     = lg.RoundDown
+	RoundCeiling  = lg.RoundCeiling
+	RoundFloor    = lg.RoundFloor
+)
+
+const (
+	StepTypeString = lg.StepTypeString
+	StepTypeNumber = lg.StepTypeNumber
+)
+
+// ParsePath parses a dot separated path, like "a.b.0.c".
+func ParsePath(s string) (Path, error) {
+	return lg.ParsePath(s)
+}
+
+// NewValRef returns a value reference to path, same as "#<path>" in rule.
+func NewValRef(path string) (ValRef, error) {
+	p, err := lg.ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return ValRef(p), nil
+}
+
+// NewFnRef returns a function reference to path, same as "$<path>" in rule.
+func NewFnRef(path string) (FnRef, error) {
+	p, err := lg.ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return FnRef(p), nil
+}
+
+// NewFnCall returns a call of fn with args, same as ["$<fn>", args...] in rule.
+func NewFnCall(fn string, args ...Expr) (FnCall, error) {
+	ref, err := NewFnRef(fn)
+	if err != nil {
+		return FnCall{}, err
+	}
+	return FnCall{FnRef: ref, Args: args}, nil
+}
+
+// FromJSON parses json into an expression, with the same syntax as rule.
+func FromJSON(data []byte) (Expr, error) {
+	return lg.ExprFromBytes(data)
+}
+
+// ToJSON serializes an expression into json, the keys of objects are in their
+// order.
+func ToJSON(expr Expr) ([]byte, error) {
+	return lg.ExprToBytes(expr)
+}
+
+// ToSortedJSON is like ToJSON, but the keys of objects are sorted.
+func ToSortedJSON(expr Expr) ([]byte, error) {
+	return lg.ExprToSortedBytes(expr)
+}
+
+// FromValue converts a value decoded by encoding/json into an expression. Decode
+// with UseNumber to keep the digits of integers, like the rule does. A value of
+// other Go types, like int or []string, is an invalid argument.
+func FromValue(v any) (Expr, error) {
+	return lg.ExprFromValue(v)
+}
+
+// ToValue converts an expression into a value that encoding/json can encode.
+func ToValue(expr Expr) any {
+	return lg.ExprToValue(expr)
+}
+
+// AsNumber returns the number in expr, ok is false if expr is not a Number,
+// Int or Decimal. An Int or Decimal is converted to float.
+func AsNumber(expr Expr) (n float64, ok bool) {
+	switch v := expr.(type) {
+	case Number:
+		return float64(v), true
+	case Int:
+		return float64(v), true
+	case Decimal:
+		return v.Float64(), true
+	default:
+		return 0, false
+	}
+}
+
+// AsInt returns the integer in expr, ok is false if expr is not a number of an
+// integer in the range of int64.
+func AsInt(expr Expr) (n int64, ok bool) {
+	switch v := expr.(type) {
+	case Int:
+		return int64(v), true
+	case Number:
+		f := float64(v)
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	case Decimal:
+		return v.Int64()
+	default:
+		return 0, false
+	}
+}
+
+// AsDecimal returns the number in expr as a decimal, ok is false if expr is
+// not a Decimal, an Int or a finite Number.
+func AsDecimal(expr Expr) (d Decimal, ok bool) {
+	switch v := expr.(type) {
+	case Decimal:
+		return v, true
+	case Int:
+		return lg.NewDecimal(int64(v), 0), true
+	case Number:
+		d, err := lg.DecimalFromFloat(float64(v))
+		return d, err == nil
+	default:
+		return Decimal{}, false
+	}
+}
+
+// ParseDecimal parses a decimal in json number format, like "12.50".
+func ParseDecimal(s string) (Decimal, error) {
+	return lg.ParseDecimal(s)
+}
+
+// NewDecimal returns coef * 10^-scale, like NewDecimal(1250, 2) is 12.50.
+func NewDecimal(coef int64, scale int32) Decimal {
+	return lg.NewDecimal(coef, scale)
+}
+
+// NewObject returns an empty object with space for size keys, the keys are kept
+// in the order they are set.
+func NewObject(size int) Object {
+	return lg.NewObject(size)
+}
+
+// ObjectOf returns an object of the fields in m, the keys are sorted.
+func ObjectOf(m map[string]Expr) Object {
+	return lg.ObjectOf(m)
+}
+
+// AsString returns the string in expr, ok is false if expr is not a String.
+func AsString(expr Expr) (s string, ok bool) {
+	v, ok := expr.(String)
+	return string(v), ok
+}
+
+// AsBool returns the bool in expr, ok is false if expr is not a Bool.
+func AsBool(expr Expr) (b bool, ok bool) {
+	v, ok := expr.(Bool)
+	return bool(v), ok
+}
+
+// AsArray returns the elements in expr, ok is false if expr is not an Array.
+func AsArray(expr Expr) (a []Expr, ok bool) {
+	v, ok := expr.(Array)
+	return v, ok
+}
+
+// AsObject returns the object in expr, ok is false if expr is not an Object.
+func AsObject(expr Expr) (o Object, ok bool) {
+	v, ok := expr.(Object)
+	return v, ok
+}
+
+// Equal reports whether a and b are deeply equal values.
+func Equal(a, b Expr) bool {
+	return lg.Equal(a, b)
+}
+
+// IsNull reports whether expr is null.
+func IsNull(expr Expr) bool {
+	return expr == nil || expr.Type() == ExprNull
+}